@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	h := basicAuth(ok, "user", "secret")
+	for _, tc := range []struct {
+		name       string
+		user, pass string
+		noAuth     bool
+		want       int
+	}{
+		{name: "no credentials", noAuth: true, want: http.StatusUnauthorized},
+		{name: "wrong user", user: "other", pass: "secret", want: http.StatusUnauthorized},
+		{name: "wrong password", user: "user", pass: "guess", want: http.StatusUnauthorized},
+		{name: "empty password", user: "user", want: http.StatusUnauthorized},
+		{name: "right credentials", user: "user", pass: "secret", want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if !tc.noAuth {
+				r.SetBasicAuth(tc.user, tc.pass)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d", w.Code, tc.want)
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if tc.want == http.StatusUnauthorized && challenge == "" {
+				t.Error("no WWW-Authenticate challenge on 401")
+			}
+			if tc.want == http.StatusOK && w.Body.String() != "ok" {
+				t.Errorf("body = %q, want the wrapped handler's", w.Body.String())
+			}
+		})
+	}
+}
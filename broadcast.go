@@ -0,0 +1,63 @@
+package main
+
+import "sync"
+
+// streamedReading is a reading sent to clients streaming readings as they're made.
+type streamedReading struct {
+	Port string `json:"port"`
+	reading
+}
+
+// subscriberBuffer is how many readings a subscriber can fall behind by before
+// it's dropped, so that a slow client can't hold up the sensors.
+const subscriberBuffer = 16
+
+// broadcaster fans readings out from the sensors to the clients streaming them.
+type broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan streamedReading]bool
+
+	done      chan struct{} // closed by shutdown
+	closeDone sync.Once
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subscribers: make(map[chan streamedReading]bool), done: make(chan struct{})}
+}
+
+// shutdown ends every stream, since the HTTP server waits for them to finish
+// before it shuts down.
+func (b *broadcaster) shutdown() {
+	b.closeDone.Do(func() { close(b.done) })
+}
+
+// subscribe returns a channel of readings as they're published, which is closed
+// if the subscriber falls too far behind, and a function to unsubscribe.
+func (b *broadcaster) subscribe() (<-chan streamedReading, func()) {
+	ch := make(chan streamedReading, subscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = true
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends r to every subscriber without blocking, dropping any with a full buffer.
+func (b *broadcaster) publish(r streamedReading) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- r:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
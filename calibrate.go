@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// calibrateZero handles POST /calibrate/zero by sending a zero point calibration command.
+func (c *mhz19Collector) calibrateZero(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	c.calibrate(w, "zero point calibration", newZeroCalibrationCommand())
+}
+
+// calibrateSpan handles POST /calibrate/span?ppm=N by sending a span point calibration command.
+func (c *mhz19Collector) calibrateSpan(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	param := r.URL.Query().Get("ppm")
+	ppm, err := strconv.ParseUint(param, 10, 16)
+	if err != nil || ppm < minSpanPPM || ppm > maxSpanPPM {
+		slog.Warn("rejected span point calibration with invalid ppm", "portname", c.portname, "ppm", param)
+		http.Error(w, fmt.Sprintf("ppm must be a whole number between %d and %d, got %q", minSpanPPM, maxSpanPPM, param), http.StatusBadRequest)
+		return
+	}
+	c.calibrate(w, fmt.Sprintf("span point calibration at %dppm", ppm), newSpanCalibrationCommand(uint16(ppm)))
+}
+
+// calibrationDisabled handles calibration requests without --enable-calibration,
+// refusing them.
+func calibrationDisabled(w http.ResponseWriter, r *http.Request) {
+	slog.Warn("rejected calibration request, since --enable-calibration wasn't given", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	http.Error(w, "calibration is disabled; start the exporter with --enable-calibration to allow it", http.StatusForbidden)
+}
+
+// requirePost reports whether r is a POST, responding with an error if not.
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		slog.Warn("rejected calibration request that wasn't a POST", "path", r.URL.Path, "method", r.Method)
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "calibration must be requested with POST", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// calibrate sends a calibration command to the sensor and reports the outcome,
+// responding 502 Bad Gateway if it couldn't be sent, since the sensor is
+// upstream of the exporter.
+func (c *mhz19Collector) calibrate(w http.ResponseWriter, name string, cmd *command) {
+	if err := c.sensor.Command(cmd); err != nil {
+		slog.Error("calibration failed", "calibration", name, "portname", c.portname, "error", err)
+		http.Error(w, fmt.Sprintf("%v failed: couldn't send it to the sensor: %v", name, err), http.StatusBadGateway)
+		return
+	}
+	slog.Info("calibration sent", "calibration", name, "portname", c.portname)
+	fmt.Fprintf(w, "%v sent\n", name)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// captureFile writes a serial port's traffic to a capture file, in the format
+// --replay reads.
+type captureFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// createCapture creates a capture file, or truncates an existing one.
+func createCapture(path string) (*captureFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &captureFile{f: f}, nil
+}
+
+// record writes a line for a read (<) or write (>) of data, or the error a read
+// returned instead. Failures to write the capture are ignored, so they don't
+// disturb reading the sensor.
+func (c *captureFile) record(direction string, data []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := time.Now().UTC().Format(time.RFC3339Nano)
+	if len(data) > 0 {
+		fmt.Fprintf(c.f, "%s %s % x\n", t, direction, data)
+	}
+	if err != nil && direction == "<" {
+		fmt.Fprintf(c.f, "%s %s %v\n", t, direction, err)
+	}
+}
+
+func (c *captureFile) close() error {
+	return c.f.Close()
+}
+
+// withCapture wraps open to record each port's traffic in capture.
+func withCapture(open func(serial.OpenOptions) (io.ReadWriteCloser, error), capture *captureFile) func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+	return func(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+		serialPort, err := open(options)
+		if err != nil {
+			return nil, err
+		}
+		return &capturingPort{serialPort, capture}, nil
+	}
+}
+
+// capturingPort tees the bytes read from and written to a serial port into a
+// capture file, leaving them otherwise as they were.
+type capturingPort struct {
+	io.ReadWriteCloser
+	capture *captureFile
+}
+
+func (p *capturingPort) Read(b []byte) (int, error) {
+	n, err := p.ReadWriteCloser.Read(b)
+	p.capture.record("<", b[:n], err)
+	return n, err
+}
+
+func (p *capturingPort) Write(b []byte) (int, error) {
+	n, err := p.ReadWriteCloser.Write(b)
+	p.capture.record(">", b[:n], nil)
+	return n, err
+}
+
+// Unwrap returns the serial port being captured.
+func (p *capturingPort) Unwrap() io.ReadWriteCloser {
+	return p.ReadWriteCloser
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envFlags maps flags to the environment variables they fall back to when they
+// aren't given on the command line.
+var envFlags = map[string]string{
+	"portname": "MHZ19_PORTNAME",
+	"port":     "MHZ19_PORT",
+	"baudrate": "MHZ19_BAUDRATE",
+}
+
+// setFlagsFromEnv sets each flag in envFlags that wasn't given on the command line
+// from its environment variable, if that's set. Call it after flag.Parse.
+func setFlagsFromEnv() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, env := range envFlags {
+		value, ok := os.LookupEnv(env)
+		if !ok || set[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%v=%q: %v", env, value, err)
+		}
+	}
+	return nil
+}
+
+// setFlagsFromFile sets flags from a YAML config file mapping flag names to
+// values, e.g.
+//
+//	portname: [/dev/ttyUSB0, /dev/ttyUSB1]
+//	baudrate: 9600
+//	poll-interval: 10s
+//
+// Flags given on the command line or from the environment take precedence, so
+// call it after setFlagsFromEnv. Errors give the line of the offending key.
+func setFlagsFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil // empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%v:%d: want a mapping of flag names to values", path, root.Line)
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value == "config" || flag.Lookup(key.Value) == nil {
+			return fmt.Errorf("%v:%d: unknown flag %q", path, key.Line, key.Value)
+		}
+		if set[key.Value] {
+			continue
+		}
+		values := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			values = value.Content
+		}
+		for _, v := range values {
+			if v.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%v:%d: %v must be a value or a list of values", path, v.Line, key.Value)
+			}
+			if err := flag.Set(key.Value, v.Value); err != nil {
+				return fmt.Errorf("%v:%d: %v: %v", path, v.Line, key.Value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// secretFlags are flags whose values effectiveConfig redacts.
+var secretFlags = map[string]bool{
+	"auth-pass":    true,
+	"influx-token": true,
+	"tls-key":      true,
+}
+
+// urlFlags are flags whose values are URLs, which may have a password.
+var urlFlags = map[string]bool{
+	"influx-url":      true,
+	"mqtt-broker":     true,
+	"otlp-endpoint":   true,
+	"pushgateway-url": true,
+}
+
+// effectiveConfig returns the value of every flag once the command line,
+// environment and config file have been applied, with secrets redacted.
+func effectiveConfig() map[string]string {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch {
+		case secretFlags[f.Name] && value != "":
+			value = "REDACTED"
+		case urlFlags[f.Name]:
+			if u, err := url.Parse(value); err == nil && u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					u.User = url.UserPassword(u.User.Username(), "REDACTED")
+				}
+				value = u.String()
+			}
+		}
+		config[f.Name] = value
+	})
+	return config
+}
+
+// serveConfig handles GET /config, responding with effectiveConfig as JSON.
+func serveConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(effectiveConfig())
+}
@@ -0,0 +1,36 @@
+package main
+
+import "io"
+
+// countingPort counts the bytes read from and written to a serial port in its
+// serialSensor's stats.
+type countingPort struct {
+	io.ReadWriteCloser
+	s *serialSensor
+}
+
+// countBytes wraps a serial port to count its bytes in s's stats.
+func (s *serialSensor) countBytes(serialPort io.ReadWriteCloser) io.ReadWriteCloser {
+	return &countingPort{serialPort, s}
+}
+
+func (p *countingPort) Read(b []byte) (int, error) {
+	n, err := p.ReadWriteCloser.Read(b)
+	p.s.mu.Lock()
+	p.s.stats.bytesRead += uint64(n)
+	p.s.mu.Unlock()
+	return n, err
+}
+
+func (p *countingPort) Write(b []byte) (int, error) {
+	n, err := p.ReadWriteCloser.Write(b)
+	p.s.mu.Lock()
+	p.s.stats.bytesWritten += uint64(n)
+	p.s.mu.Unlock()
+	return n, err
+}
+
+// Unwrap returns the serial port being counted.
+func (p *countingPort) Unwrap() io.ReadWriteCloser {
+	return p.ReadWriteCloser
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// debugFrame handles GET /debug/frame, showing the last complete response frame
+// read from the sensor in hex, and whether its checksum was right.
+func (c *mhz19Collector) debugFrame(w http.ResponseWriter, r *http.Request) {
+	stats, _ := c.serialStats()
+	frame, t := stats.lastFrame, stats.lastFrameTime
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if frame == nil {
+		http.Error(w, "no response frame read from sensor yet", http.StatusNotFound)
+		return
+	}
+	status := "ok"
+	if want := checksum(frame); frame[8] != want {
+		status = "mismatch"
+	}
+	fmt.Fprintf(w, "frame: % x\n", frame)
+	fmt.Fprintf(w, "checksum: received %#02x computed %#02x (%v)\n", frame[8], checksum(frame), status)
+	fmt.Fprintf(w, "read: %v (%v ago)\n", t.Format(time.RFC3339Nano), time.Since(t).Round(time.Millisecond))
+}
@@ -0,0 +1,248 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// metricDescs are the descriptors of a sensor's metrics. They're created once
+// per sensor, since each has the sensor's serial port as a const label.
+type metricDescs struct {
+	up                          *prometheus.Desc
+	exporterInfo                *prometheus.Desc
+	serialConnected             *prometheus.Desc
+	serialReconnectsTotal       *prometheus.Desc
+	serialBytesReadTotal        *prometheus.Desc
+	serialBytesWrittenTotal     *prometheus.Desc
+	writeErrorsTotal            *prometheus.Desc
+	checksumErrorsTotal         *prometheus.Desc
+	readErrorsTotal             *prometheus.Desc
+	firmwareInfo                *prometheus.Desc
+	detectionRangePPM           *prometheus.Desc
+	parseErrorsTotal            *prometheus.Desc
+	glitchReadingsTotal         *prometheus.Desc
+	readRetriesTotal            *prometheus.Desc
+	co2PPMSecondsTotal          *prometheus.Desc
+	lastSuccessTimestampSeconds *prometheus.Desc
+	exporterUptimeSeconds       *prometheus.Desc
+	sensorResponding            *prometheus.Desc
+	startupOK                   *prometheus.Desc
+	warmingUp                   *prometheus.Desc
+	co2ConcentrationPPM         *prometheus.Desc
+	co2ConcentrationPPMRaw      *prometheus.Desc
+	airQualityLevel             *prometheus.Desc
+	co2AtRangeLimit             *prometheus.Desc
+	co2PPMPerMinute             *prometheus.Desc
+	co2ConcentrationPPMSmoothed *prometheus.Desc
+	co2RawPPM                   *prometheus.Desc
+	sensorStatus                *prometheus.Desc
+	temperatureCelsius          *prometheus.Desc
+	temperatureRawCelsius       *prometheus.Desc
+	dewPointCelsius             *prometheus.Desc
+	temperatureFahrenheit       *prometheus.Desc
+}
+
+// newMetricDescs creates the descriptors of the metrics of the sensor on port.
+// It depends on prefix, so must be called once it's set.
+func newMetricDescs(port string) *metricDescs {
+	labels := prometheus.Labels{"port": port}
+	return &metricDescs{
+		up: prometheus.NewDesc(
+			prefix+"_up",
+			"Whether the last read of the sensor succeeded (1) or not, or it hasn't been read yet (0)",
+			nil,
+			labels),
+		exporterInfo: prometheus.NewDesc(
+			prefix+"_exporter_info",
+			"Always 1, labelled with the exporter's version, for joining the sensor's metrics to it by port",
+			[]string{"version"},
+			labels),
+		serialConnected: prometheus.NewDesc(
+			prefix+"_serial_connected",
+			"Whether the serial port is currently open (1) or being reopened after an error (0)",
+			nil,
+			labels),
+		serialReconnectsTotal: prometheus.NewDesc(
+			prefix+"_serial_reconnects_total",
+			"Number of times the serial port was reopened after an error",
+			nil,
+			labels),
+		serialBytesReadTotal: prometheus.NewDesc(
+			prefix+"_serial_bytes_read_total",
+			"Number of bytes read from the serial port, valid or not",
+			nil,
+			labels),
+		serialBytesWrittenTotal: prometheus.NewDesc(
+			prefix+"_serial_bytes_written_total",
+			"Number of bytes written to the serial port",
+			nil,
+			labels),
+		writeErrorsTotal: prometheus.NewDesc(
+			prefix+"_write_errors_total",
+			"Number of failed writes of requests to the serial port",
+			nil,
+			labels),
+		checksumErrorsTotal: prometheus.NewDesc(
+			prefix+"_checksum_errors_total",
+			"Number of responses from the sensor whose last byte failed their checksum",
+			nil,
+			labels),
+		readErrorsTotal: prometheus.NewDesc(
+			prefix+"_read_errors_total",
+			"Number of failed reads of responses from the serial port, by reason",
+			[]string{"reason"},
+			labels),
+		firmwareInfo: prometheus.NewDesc(
+			prefix+"_firmware_info",
+			"Firmware version the sensor reported to command 0xA0 at startup",
+			[]string{"version"},
+			labels),
+		detectionRangePPM: prometheus.NewDesc(
+			prefix+"_detection_range_ppm",
+			"Detection range in parts per million that the sensor reported to command 0x9B at startup, or else the one --detection-range set, usually 2000 or 5000",
+			nil,
+			labels),
+		parseErrorsTotal: prometheus.NewDesc(
+			prefix+"_parse_errors_total",
+			"Number of invalid responses from the sensor, by what was wrong with them",
+			[]string{"kind"},
+			labels),
+		glitchReadingsTotal: prometheus.NewDesc(
+			prefix+"_glitch_readings_total",
+			"Number of readings ignored because their CO2 concentration changed by more than --max-delta",
+			nil,
+			labels),
+		readRetriesTotal: prometheus.NewDesc(
+			prefix+"_read_retries_total",
+			"Number of reads of the sensor retried after a timeout, checksum error or short read",
+			nil,
+			labels),
+		co2PPMSecondsTotal: prometheus.NewDesc(
+			prefix+"_co2_ppm_seconds_total",
+			"Carbon Dioxide Concentration in parts per million, corrected by --co2-scale and --co2-offset, integrated over the time between readings",
+			nil,
+			labels),
+		lastSuccessTimestampSeconds: prometheus.NewDesc(
+			prefix+"_last_success_timestamp_seconds",
+			"Unix time of the last successful reading from the sensor, or 0 if there hasn't been one",
+			nil,
+			labels),
+		exporterUptimeSeconds: prometheus.NewDesc(
+			prefix+"_exporter_uptime_seconds",
+			"Time since the exporter started",
+			nil,
+			labels),
+		sensorResponding: prometheus.NewDesc(
+			prefix+"_sensor_responding",
+			"Whether the sensor is responding (1), or hasn't been read yet or its last --failure-threshold reads failed (0)",
+			nil,
+			labels),
+		startupOK: prometheus.NewDesc(
+			prefix+"_startup_ok",
+			"Whether every command sent to the sensor at startup that it supports succeeded (1), or some failed and were skipped (0)",
+			nil,
+			labels),
+		warmingUp: prometheus.NewDesc(
+			prefix+"_warming_up",
+			"Whether the sensor is still in its warm-up period after startup (1) or not (0)",
+			nil,
+			labels),
+		co2ConcentrationPPM: prometheus.NewDesc(
+			prefix+"_co2_concentration_ppm",
+			"Carbon Dioxide Concentration in parts per million, read with command 0x86, from 0 up to the detection range, corrected by --co2-scale and --co2-offset",
+			nil,
+			labels),
+		co2ConcentrationPPMRaw: prometheus.NewDesc(
+			prefix+"_co2_concentration_ppm_raw",
+			"Carbon Dioxide Concentration in parts per million, read with command 0x86, from 0 up to the detection range, as the sensor reported it",
+			nil,
+			labels),
+		airQualityLevel: prometheus.NewDesc(
+			prefix+"_air_quality_level",
+			"Air quality by the Carbon Dioxide Concentration: 0 is good, 1 is at least --co2-warn, and 2 at least --co2-alert",
+			nil,
+			labels),
+		co2AtRangeLimit: prometheus.NewDesc(
+			prefix+"_co2_at_range_limit",
+			"Whether the Carbon Dioxide Concentration is at the sensor's detection range limit, so the actual concentration may be higher",
+			nil,
+			labels),
+		co2PPMPerMinute: prometheus.NewDesc(
+			prefix+"_co2_ppm_per_minute",
+			"Rate of change of the Carbon Dioxide Concentration between the last two readings, in parts per million per minute",
+			nil,
+			labels),
+		co2ConcentrationPPMSmoothed: prometheus.NewDesc(
+			prefix+"_co2_concentration_ppm_smoothed",
+			"Mean Carbon Dioxide Concentration of the most recent readings, in parts per million",
+			nil,
+			labels),
+		co2RawPPM: prometheus.NewDesc(
+			prefix+"_co2_raw_ppm",
+			"Raw Carbon Dioxide Concentration in parts per million, read with the undocumented command 0x85, before the sensor clamps and smooths it",
+			nil,
+			labels),
+		sensorStatus: prometheus.NewDesc(
+			prefix+"_sensor_status",
+			"Undocumented status byte of the sensor's response, which some sensors use to report preheating or faults",
+			nil,
+			labels),
+		temperatureCelsius: prometheus.NewDesc(
+			prefix+"_temperature_celsius",
+			"Sensor Temperature in degrees Celsius, read with command 0x86, corrected by --temp-offset. It's the temperature inside the sensor, which runs a few degrees above the air",
+			nil,
+			labels),
+		temperatureRawCelsius: prometheus.NewDesc(
+			prefix+"_temperature_raw_celsius",
+			"Sensor Temperature in degrees Celsius, read with command 0x86, as the sensor reported it, in whole degrees",
+			nil,
+			labels),
+		dewPointCelsius: prometheus.NewDesc(
+			prefix+"_dew_point_celsius",
+			"Dew point in degrees Celsius, from the sensor temperature and the relative humidity posted to /humidity",
+			nil,
+			labels),
+		temperatureFahrenheit: prometheus.NewDesc(
+			prefix+"_temperature_fahrenheit",
+			"Sensor Temperature in degrees Fahrenheit, converted from temperature_celsius",
+			nil,
+			labels),
+	}
+}
+
+// describe sends every descriptor to ch, for Describe.
+func (d *metricDescs) describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		d.up,
+		d.exporterInfo,
+		d.serialConnected,
+		d.serialReconnectsTotal,
+		d.serialBytesReadTotal,
+		d.serialBytesWrittenTotal,
+		d.writeErrorsTotal,
+		d.checksumErrorsTotal,
+		d.readErrorsTotal,
+		d.firmwareInfo,
+		d.detectionRangePPM,
+		d.parseErrorsTotal,
+		d.glitchReadingsTotal,
+		d.readRetriesTotal,
+		d.co2PPMSecondsTotal,
+		d.lastSuccessTimestampSeconds,
+		d.exporterUptimeSeconds,
+		d.sensorResponding,
+		d.startupOK,
+		d.warmingUp,
+		d.co2ConcentrationPPM,
+		d.co2ConcentrationPPMRaw,
+		d.airQualityLevel,
+		d.co2AtRangeLimit,
+		d.co2PPMPerMinute,
+		d.co2ConcentrationPPMSmoothed,
+		d.co2RawPPM,
+		d.sensorStatus,
+		d.temperatureCelsius,
+		d.temperatureRawCelsius,
+		d.dewPointCelsius,
+		d.temperatureFahrenheit,
+	} {
+		ch <- desc
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// portPatterns are where detectPort looks for a sensor, in order.
+var portPatterns = []string{"/dev/ttyUSB*", "/dev/ttyAMA*", "/dev/serial0"}
+
+// findPortHelp suggests how to find a sensor's serial port when detectPort can't.
+const findPortHelp = "a sensor on a USB serial adapter is usually /dev/ttyUSB0 or /dev/ttyACM0: " +
+	"compare ls /dev/tty* with it unplugged and plugged in, or look in dmesg; " +
+	"one on a Raspberry Pi's GPIO pins is /dev/serial0 once the serial port is enabled in raspi-config"
+
+// detectPort returns the first serial port matching portPatterns that answers a
+// gas concentration request, for when --portname isn't given. options gives the
+// options to open a port with.
+func detectPort(open func(serial.OpenOptions) (io.ReadWriteCloser, error), options func(string) serial.OpenOptions, timeout time.Duration) (string, error) {
+	var tried []string
+	for _, pattern := range portPatterns {
+		names, _ := filepath.Glob(pattern) // the patterns are well formed
+		for _, name := range names {
+			tried = append(tried, name)
+			if err := probe(open, options(name), timeout); err != nil {
+				slog.Info("no sensor found on serial port", "portname", name, "error", err)
+				continue
+			}
+			return name, nil
+		}
+	}
+	if len(tried) == 0 {
+		return "", fmt.Errorf("no serial ports match %v", strings.Join(portPatterns, " "))
+	}
+	return "", fmt.Errorf("no sensor answered on %v", strings.Join(tried, " "))
+}
+
+// probe reports whether a sensor on the serial port answers a gas concentration
+// request within timeout, or 0 for no limit.
+func probe(open func(serial.OpenOptions) (io.ReadWriteCloser, error), options serial.OpenOptions, timeout time.Duration) error {
+	serialPort, err := open(options)
+	if err != nil {
+		return err
+	}
+	defer serialPort.Close()
+	if err := newGasConcentrationRequest().Write(serialPort); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	_, err = readGasConcentrationResponseContext(ctx, serialPort)
+	return err
+}
@@ -2,113 +2,1228 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
 	"io"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
 	"sync"
+	"syscall"
 	"text/template"
+	"time"
 
-	"log"
-
-	"github.com/mhansen/mhz19"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/jacobsa/go-serial/serial"
 )
 
-const prefix = "mhz19"
+// prefix starts the name of every metric, from --metric-prefix.
+var prefix = "mhz19"
+
+// metricPrefixRE matches valid --metric-prefix values, following Prometheus's metric name rules.
+var metricPrefixRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// errDisconnected is returned when the serial port is closed and couldn't be reopened.
+var errDisconnected = errors.New("serial port is not open")
+
+// shutdownTimeout bounds how long to wait for in-flight requests on SIGINT or SIGTERM.
+const shutdownTimeout = 10 * time.Second
+
+// maxConsecutiveGlitches is how many readings in a row --max-delta ignores
+// before accepting the next as a real change, e.g. after the sensor restarts.
+const maxConsecutiveGlitches = 3
+
+// retryDelay is how long to wait before the first retry of a failed read. It doubles for each retry after that.
+const retryDelay = 100 * time.Millisecond
+
+// maxRawNoAnswers is how many raw CO2 requests in a row a sensor that has never
+// answered one must leave unanswered before it's assumed not to support them,
+// rather than having missed one.
+const maxRawNoAnswers = 3
+
+// maxOpenBackoff caps the delay between attempts to reopen a disconnected serial port.
+const maxOpenBackoff = time.Minute
 
 var (
-	portname = flag.String("portname", "", "filename of serial port")
-	port     = flag.String("port", ":8080", "http port to listen on")
-	index    = template.Must(template.New("index").Parse(
+	configFile        = flag.String("config", "", "YAML file of flag names and values, e.g. \"baudrate: 9600\", for flags not given on the command line or from the environment")
+	port              = flag.String("port", ":8080", "http port to listen on, or unix:<path> for a Unix socket (or $MHZ19_PORT if the flag isn't given)")
+	baudrate          = flag.Uint("baudrate", 9600, "baud rate of serial port (or $MHZ19_BAUDRATE if the flag isn't given)")
+	sensorNumberFlag  = flag.Uint("sensor-number", 0x01, "sensor number to address commands to, from 0 to 0xFF; sensors answer the default, 0x01, but some clones and daisy-chained setups need another")
+	dtr               = flag.String("dtr", "", "set the serial port's DTR line on or off after opening it, for USB serial adapters that wire it to the sensor's reset; left as the driver sets it if empty. Adapters that hold the sensor in reset usually need --dtr=off --rts=off")
+	rts               = flag.String("rts", "", "set the serial port's RTS line on or off after opening it, like --dtr; left as the driver sets it if empty")
+	httpReadTimeout   = flag.Duration("http-read-timeout", 10*time.Second, "give up reading an HTTP request, including its body, after this long, or 0 for no limit")
+	httpWriteTimeout  = flag.Duration("http-write-timeout", 30*time.Second, "give up on an HTTP request after this long from the end of reading its headers, or 0 for no limit; must allow for reading the sensor")
+	tlsCert           = flag.String("tls-cert", "", "file containing a TLS certificate to serve HTTPS with; requires --tls-key")
+	tlsKey            = flag.String("tls-key", "", "file containing the private key for --tls-cert")
+	authUser          = flag.String("auth-user", "", "username required by HTTP basic auth on every endpoint that serves readings or commands the sensor, which is all but /healthz and /config; requires --auth-pass")
+	authPass          = flag.String("auth-pass", "", "password required along with --auth-user")
+	corsOrigin        = flag.String("cors-origin", "", "origin allowed to fetch /reading.json from browsers, e.g. https://dashboard.example.com or *; CORS is disabled if empty")
+	corsMetrics       = flag.Bool("cors-metrics", false, "with --cors-origin, allow browsers to fetch /metrics and /metrics-lite too")
+	mqttBroker        = flag.String("mqtt-broker", "", "MQTT broker to publish readings to, e.g. tcp://localhost:1883; disabled if empty")
+	mqttTopic         = flag.String("mqtt-topic", "mhz19", "MQTT topic prefix; each sensor publishes to <prefix>/<serial port base name>")
+	mqttInterval      = flag.Duration("mqtt-interval", 30*time.Second, "how often to publish readings to MQTT")
+	haDiscovery       = flag.Bool("ha-discovery", false, "publish Home Assistant MQTT discovery configs for each sensor at startup; requires --mqtt-broker")
+	pushgatewayURL    = flag.String("pushgateway-url", "", "Prometheus Pushgateway to push metrics to every --poll-interval, e.g. http://localhost:9091, as well as serving them; disabled if empty")
+	otlpEndpoint      = flag.String("otlp-endpoint", "", "OpenTelemetry OTLP/HTTP endpoint to export readings to every --poll-interval, e.g. http://localhost:4318, as well as serving them; disabled if empty")
+	statsdAddr        = flag.String("statsd-addr", "", "StatsD server to send readings to every --poll-interval, e.g. localhost:8125, as well as serving them; disabled if empty")
+	statsdPrefix      = flag.String("statsd-prefix", "mhz19", "prefix of the StatsD gauge names")
+	statsdFormat      = flag.String("statsd-format", "statsd", "StatsD format: statsd, or dogstatsd to tag the gauges with the serial port")
+	influxURL         = flag.String("influx-url", "", "InfluxDB 2 server to write readings to every --poll-interval, e.g. http://localhost:8086, as well as serving them; disabled if empty; requires --influx-bucket")
+	influxOrg         = flag.String("influx-org", "", "InfluxDB organization owning --influx-bucket, if the server needs it")
+	influxBucket      = flag.String("influx-bucket", "", "InfluxDB bucket to write readings to")
+	influxToken       = flag.String("influx-token", "", "InfluxDB API token to write with")
+	openRetries       = flag.Int("open-retries", 0, "how many times to retry opening each serial port at startup, e.g. while a USB adapter is still being detected at boot")
+	openRetryInterval = flag.Duration("open-retry-interval", time.Second, "how long to wait before the first retry of opening a serial port at startup; it doubles for each retry after that")
+	interCharTimeout  = flag.Uint("inter-char-timeout", 1000, "milliseconds the serial port waits for the next byte of a response before giving up, rounded to a multiple of 100, from 100 to 25500")
+	readTimeout       = flag.Duration("read-timeout", 3*time.Second, "give up reading a response from the sensor after this long, or 0 for no limit")
+	readRetries       = flag.Int("read-retries", 2, "how many times to retry reading the sensor after a timeout, checksum error or short read")
+	mock              = flag.Bool("mock", false, "read from simulated in-memory sensors instead of serial ports, for trying the exporter out")
+	mockRamp          = flag.Bool("mock-ramp", false, "with --mock, ramp the simulated CO2 concentration up and down over time")
+	replay            = flag.String("replay", "", "read from a sensor that replays what one sent in this capture file, instead of a serial port, to reproduce a problem")
+	captureFlag       = flag.String("capture", "", "record every byte read from and written to the serial port in this file, with timestamps, to attach to a bug report or --replay; requires a single --portname")
+	readingsFile      = flag.String("log-readings-file", "", "append each reading to this file as a line of JSON, for a record independent of Prometheus; disabled if empty")
+	readingsMaxSize   = flag.Int64("log-readings-max-size", 0, "once --log-readings-file reaches this many bytes, rename it with a .1 suffix, replacing any older one, and start a new one; 0 lets it grow")
+	once              = flag.Bool("once", false, "read each sensor once, print the readings to stdout in --log-format, then exit, rather than serving metrics")
+	printVersion      = flag.Bool("version", false, "print the version, commit and build date, then exit")
+	logFormat         = flag.String("log-format", "text", "log format: text or json")
+	logLevel          = flag.String("log-level", "info", "minimum level of messages to log: debug, info, warn or error")
+	logDedupInterval  = flag.Duration("log-dedup-interval", time.Minute, "log repeats of the same warning or error at most once per this long, or 0 to log every one")
+	warmup            = flag.Duration("warmup", 3*time.Minute, "how long after startup the sensor is considered to be warming up")
+	minValidPPM       = flag.Uint("min-valid-ppm", 1, "while the sensor is warming up, don't export CO2 concentrations below this, such as the 0 it reports while preheating")
+	maxDelta          = flag.Uint("max-delta", 0, fmt.Sprintf("ignore a reading whose CO2 concentration differs from the previous one by more than this many ppm, as a glitch, up to %d in a row, accepting the next as a real change; disabled if 0", maxConsecutiveGlitches))
+	warmupSuppress    = flag.Bool("warmup-suppress", false, "don't export the CO2 concentration while the sensor is warming up")
+	smoothingWindow   = flag.Int("smoothing-window", 0, "also export the mean CO2 concentration of this many most recent readings, or 0 not to")
+	co2Warn           = flag.Float64("co2-warn", 1000, "CO2 concentration in ppm, after corrections, from which the air_quality_level metric is 1 (warn)")
+	co2Alert          = flag.Float64("co2-alert", 1500, "CO2 concentration in ppm, after corrections, from which the air_quality_level metric is 2 (alert); must be above --co2-warn")
+	co2Offset         = flag.Float64("co2-offset", 0, "ppm to add to the sensor's CO2 concentration after multiplying it by --co2-scale")
+	co2Scale          = flag.Float64("co2-scale", 1, "factor to multiply the sensor's CO2 concentration by, to correct it against a reference meter")
+	tempOffset        = flag.Float64("temp-offset", 0, "degrees Celsius to add to the sensor's temperature, e.g. -3 to correct for it heating itself")
+	emitFahrenheit    = flag.Bool("emit-fahrenheit", false, "also export the temperature in degrees Fahrenheit")
+	emitRaw           = flag.Bool("emit-raw", false, "also read and export the sensor's raw, unsmoothed CO2 concentration, if its firmware supports it")
+	minReadInterval   = flag.Duration("min-read-interval", 0, "reuse the last reading rather than reading the sensor again within this long, however often it's scraped")
+	metricsCacheTTL   = flag.Duration("metrics-cache-ttl", 0, "serve the sensors' metrics last gathered, on both /metrics and /metrics-lite, rather than gathering them again, for up to this long, to protect the sensors from scrape storms; disabled if 0")
+	pollInterval      = flag.Duration("poll-interval", 5*time.Second, "how often to read the sensor in the background, or 0 to read it on every scrape")
+	pollJitter        = flag.Float64("poll-jitter", 0, "randomly lengthen or shorten each --poll-interval by up to this fraction of it, from 0 to 0.5, so exporters started together don't read and push in step")
+	abc               = flag.String("abc", "", "turn the sensor's Automatic Baseline Correction on or off at startup; leaves it unchanged if empty")
+	abcPeriod         = flag.Int("abc-period", 0, "turn the sensor's Automatic Baseline Correction on at startup, running every this many hours, from 1 to 38, if its firmware supports it; the sensor's default is 24. Leaves it unchanged if 0")
+	detectionRange    = flag.Uint("detection-range", 0, "set the sensor's detection range to 2000 or 5000ppm at startup; leaves it unchanged if 0")
+	debugMode         = flag.Bool("debug", false, "serve GET /debug/frame, showing the last response frame read from the sensor")
+	expvarEnabled     = flag.Bool("expvar", false, "serve each sensor's latest reading and error counts at /debug/vars with expvar")
+	enableHumidity    = flag.Bool("enable-humidity", false, "serve POST /humidity?rh=N to accept the relative humidity from a companion sensor, and export the dew point")
+	enableCalibration = flag.Bool("enable-calibration", false, "serve POST /calibrate/zero and /calibrate/span?ppm=N to calibrate the sensor")
+	enableStream      = flag.Bool("enable-stream", false, "serve a WebSocket at /stream and server-sent events at /events that send each reading as JSON as it's made, for live dashboards")
+	failureThreshold  = flag.Int("failure-threshold", 3, "report the sensor as not responding after this many consecutive failed reads")
+	metricPrefix      = flag.String("metric-prefix", prefix, "prefix of the names of all metrics")
+	index             = template.Must(template.New("index").Parse(
 		`<!doctype html>
 	 <title>MH-Z19 Carbon Dioxide Sensor Prometheus Exporter</title>
 	 <h1>MH-Z19 Carbon Dioxide Sensor Prometheus Exporter</h1>
 	 <a href="/metrics">Metrics</a>
+	 <a href="/metrics-lite">Sensor metrics only</a>
 	 <p>
-	 <pre>portname={{.}}</pre>
+	 <pre>portname={{.Portnames}}</pre>
+	 {{range .Sensors}}
+	 <h2>{{.Port}}</h2>
+	 {{if .OK}}
+	 <p>{{.Reading.CO2}} ppm CO2, {{.Reading.Temperature}} °C, read {{.Age}} ago
+	 {{else}}
+	 <p>No reading yet
+	 {{end}}
+	 {{end}}
 	 `))
 )
 
+// readDuration is a histogram of read times, by serial port. It's created once
+// --metric-prefix is known.
+var readDuration *prometheus.HistogramVec
+
+// co2Readings is a histogram of CO2 concentrations read, by serial port, for
+// their distribution over the exporter's lifetime. It's created along with
+// readDuration. It can't share a name with the co2_concentration_ppm gauge.
+var co2Readings *prometheus.HistogramVec
+
+// createHistograms creates readDuration and co2Readings, named with prefix.
+func createHistograms() {
+	readDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    prefix + "_read_duration_seconds",
+		Help:    "Time from writing a request to the sensor to reading a valid response",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"port"})
+	co2Readings = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    prefix + "_co2_readings_ppm",
+		Help:    "Carbon Dioxide Concentrations read, in parts per million, corrected by --co2-scale and --co2-offset",
+		Buckets: []float64{400, 600, 800, 1000, 1500, 2000, 5000},
+	}, []string{"port"})
+}
+
+// portnames are the serial ports to read sensors from.
+var portnames portList
+
+func init() {
+	flag.Var(&portnames, "portname", "filename of serial port; repeat or separate with commas to read several sensors (or $MHZ19_PORTNAME if the flag isn't given); if neither is given, look for a sensor on /dev/ttyUSB*, /dev/ttyAMA* and /dev/serial0")
+}
+
+// portList is a flag.Value accumulating a list of serial ports.
+type portList []string
+
+func (l *portList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *portList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name != "" {
+			*l = append(*l, name)
+		}
+	}
+	return nil
+}
+
 func main() {
+	startTime := time.Now()
 	flag.Parse()
-	log.Printf("MH-Z19 Carbon Dioxide Sensor Prometheus Exporter starting on port %v and file %v\n", *port, *portname)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		index.Execute(w, *portname)
-	})
+	if *printVersion {
+		fmt.Println(versionString())
+		return
+	}
+	if err := setFlagsFromEnv(); err != nil {
+		fatal("invalid environment variable", "error", err)
+	}
+	if *configFile != "" {
+		if err := setFlagsFromFile(*configFile); err != nil {
+			fatal("invalid config file", "error", err)
+		}
+	}
+	logger, err := newLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fatal("invalid --log-format or --log-level", "error", err)
+	}
+	if *logDedupInterval > 0 {
+		logger = slog.New(newDedupHandler(logger.Handler(), *logDedupInterval))
+	}
+	slog.SetDefault(logger)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatal("--tls-cert and --tls-key must be given together")
+	}
+	if (*authUser == "") != (*authPass == "") {
+		fatal("--auth-user and --auth-pass must be given together")
+	}
+	if *corsMetrics && *corsOrigin == "" {
+		fatal("--cors-metrics requires --cors-origin")
+	}
+	if *haDiscovery && *mqttBroker == "" {
+		fatal("--ha-discovery requires --mqtt-broker")
+	}
+	if *pushgatewayURL != "" && *pollInterval == 0 {
+		fatal("--pushgateway-url requires a non-zero --poll-interval")
+	}
+	if *otlpEndpoint != "" && *pollInterval == 0 {
+		fatal("--otlp-endpoint requires a non-zero --poll-interval")
+	}
+	if *statsdAddr != "" && *pollInterval == 0 {
+		fatal("--statsd-addr requires a non-zero --poll-interval")
+	}
+	if *statsdFormat != "statsd" && *statsdFormat != "dogstatsd" {
+		fatal("statsd-format must be statsd or dogstatsd", "statsd_format", *statsdFormat)
+	}
+	if *influxURL != "" && *pollInterval == 0 {
+		fatal("--influx-url requires a non-zero --poll-interval")
+	}
+	if *influxURL != "" && *influxBucket == "" {
+		fatal("--influx-url requires --influx-bucket")
+	}
+	if !serial.IsStandardBaudRate(*baudrate) {
+		fatal("baudrate is not a standard serial baud rate", "baudrate", *baudrate)
+	}
+	// The serial port's timeout is in tenths of a second, from 1 to 255.
+	if *interCharTimeout < 100 || *interCharTimeout > 25500 {
+		fatal("inter-char-timeout must be from 100 to 25500 milliseconds", "inter_char_timeout", *interCharTimeout)
+	}
+	if *abc != "" && *abc != "on" && *abc != "off" {
+		fatal("abc must be on or off", "abc", *abc)
+	}
+	if *abcPeriod != 0 {
+		if *abcPeriod < minABCPeriodHours || *abcPeriod > maxABCPeriodHours {
+			fatal(fmt.Sprintf("abc-period must be from %d to %d hours", minABCPeriodHours, maxABCPeriodHours), "abc_period", *abcPeriod)
+		}
+		if *abc == "off" {
+			fatal("--abc-period turns Automatic Baseline Correction on, so can't be given with --abc=off")
+		}
+	}
+	if *detectionRange != 0 && *detectionRange != 2000 && *detectionRange != 5000 {
+		fatal("detection-range must be 2000 or 5000", "detection_range", *detectionRange)
+	}
+	if !metricPrefixRE.MatchString(*metricPrefix) {
+		fatal("metric-prefix must match "+metricPrefixRE.String(), "metric_prefix", *metricPrefix)
+	}
+	prefix = *metricPrefix
+	createHistograms()
+	if *co2Alert <= *co2Warn {
+		fatal("co2-alert must be above co2-warn", "co2_warn", *co2Warn, "co2_alert", *co2Alert)
+	}
+	if *co2Scale <= 0 {
+		fatal("co2-scale must be positive", "co2_scale", *co2Scale)
+	}
+	for name, value := range map[string]string{"dtr": *dtr, "rts": *rts} {
+		if value != "" && value != "on" && value != "off" {
+			fatal(name+" must be on or off", name, value)
+		}
+	}
+	if *sensorNumberFlag > 0xFF {
+		fatal("sensor-number must be a single byte, from 0 to 0xFF", "sensor_number", *sensorNumberFlag)
+	}
+	sensorNumber = byte(*sensorNumberFlag)
+	if *pollJitter < 0 || *pollJitter > 0.5 {
+		fatal("poll-jitter must be from 0 to 0.5", "poll_jitter", *pollJitter)
+	}
+	if *failureThreshold < 1 {
+		fatal("failure-threshold must be at least 1", "failure_threshold", *failureThreshold)
+	}
+	open := serial.Open
+	if *mock {
+		open = openMock(*mockRamp)
+		if len(portnames) == 0 {
+			portnames = portList{"mock"}
+		}
+	}
+	if *replay != "" {
+		if *mock {
+			fatal("--replay and --mock can't be given together")
+		}
+		var err error
+		if open, err = openReplay(*replay); err != nil {
+			fatal("reading --replay capture file failed", "error", err)
+		}
+		if len(portnames) == 0 {
+			portnames = portList{"replay"}
+		}
+	}
+	if *dtr != "" || *rts != "" {
+		open = withModemLines(open, *dtr, *rts)
+	}
+	openOptions := func(name string) serial.OpenOptions {
+		return serial.OpenOptions{
+			PortName:              name,
+			BaudRate:              *baudrate,
+			DataBits:              8,
+			StopBits:              1,
+			InterCharacterTimeout: *interCharTimeout,
+		}
+	}
+	if len(portnames) == 0 {
+		slog.Info("no --portname given, looking for a sensor", "patterns", strings.Join(portPatterns, " "))
+		name, err := detectPort(open, openOptions, *readTimeout)
+		if err != nil {
+			fatal("couldn't find a sensor, give its serial port with --portname", "error", err, "help", findPortHelp)
+		}
+		slog.Info("found sensor", "portname", name)
+		portnames = portList{name}
+	}
 
-	options := serial.OpenOptions{
-		PortName:              *portname,
-		BaudRate:              9600,
-		DataBits:              8,
-		StopBits:              1,
-		InterCharacterTimeout: 1000,
+	if *captureFlag != "" {
+		if len(portnames) > 1 {
+			fatal("--capture records a single serial port, but more than one --portname was given", "portnames", portnames.String())
+		}
+		capture, err := createCapture(*captureFlag)
+		if err != nil {
+			fatal("creating --capture file failed", "error", err)
+		}
+		defer capture.close()
+		open = withCapture(open, capture)
 	}
 
-	serialPort, err := serial.Open(options)
-	if err != nil {
-		log.Fatalf("serial.Open %v failed: %v", *portname, err)
+	var broadcast *broadcaster
+	if *enableStream {
+		broadcast = newBroadcaster()
+	}
+	var readings *readingsLog
+	if *readingsFile != "" {
+		var err error
+		if readings, err = openReadingsLog(*readingsFile, *readingsMaxSize); err != nil {
+			fatal("opening --log-readings-file failed", "error", err)
+		}
+		defer readings.close()
+	}
+
+	slog.Info("MH-Z19 Carbon Dioxide Sensor Prometheus Exporter starting", "port", *port, "portnames", portnames.String())
+	var collectors sensors
+	for _, name := range portnames {
+		options := openOptions(name)
+		serialPort, err := openRetrying(open, options, *openRetries, *openRetryInterval)
+		if err != nil {
+			slog.Error("serial.Open failed, skipping port", "portname", name, "baudrate", *baudrate, "error", err)
+			continue
+		}
+		collector := &mhz19Collector{
+			portname:         name,
+			sensor:           newSerialSensor(options, open, serialPort),
+			pollInterval:     *pollInterval,
+			pollJitter:       *pollJitter,
+			minReadInterval:  *minReadInterval,
+			readTimeout:      *readTimeout,
+			readRetries:      *readRetries,
+			failureThreshold: *failureThreshold,
+			started:          startTime,
+			warmup:           *warmup,
+			warmupSuppress:   *warmupSuppress,
+			minValidPPM:      uint16(*minValidPPM),
+			maxDelta:         *maxDelta,
+			readings:         readings,
+			broadcast:        broadcast,
+			co2Warn:          *co2Warn,
+			co2Alert:         *co2Alert,
+			co2Offset:        *co2Offset,
+			co2Scale:         *co2Scale,
+			tempOffset:       *tempOffset,
+			emitFahrenheit:   *emitFahrenheit,
+			emitRaw:          *emitRaw,
+		}
+		collector.descs = newMetricDescs(name)
+		if *smoothingWindow > 0 {
+			collector.smoothing = newWindow(*smoothingWindow)
+		}
+		collectors = append(collectors, collector)
+	}
+	if len(collectors) == 0 {
+		fatal("couldn't open any serial port", "portnames", portnames.String())
+	}
+	if *once {
+		ok := readOnce(collectors) && len(collectors) == len(portnames)
+		for _, collector := range collectors {
+			collector.close()
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var pollers sync.WaitGroup
+	steps := startupSteps()
+	for _, collector := range collectors {
+		collector.startup(steps)
+		if *pollInterval > 0 {
+			// Read once up front so the first scrape sees a value.
+			collector.read()
+			pollers.Add(1)
+			go func(c *mhz19Collector) {
+				defer pollers.Done()
+				c.poll(ctx)
+			}(collector)
+		}
 	}
-	defer serialPort.Close()
 
 	reg := prometheus.NewPedanticRegistry()
 	reg.MustRegister(
 		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
 		prometheus.NewGoCollector(),
 		prometheus.NewBuildInfoCollector(),
-		&mhz19Collector{serialPort: serialPort},
+		newBuildInfoGauge(),
+		readDuration,
+		co2Readings,
 	)
-	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	// The sensors' metrics alone, for small scrapes from constrained devices.
+	sensorReg := prometheus.NewPedanticRegistry()
+	for _, collector := range collectors {
+		sensorReg.MustRegister(collector)
+	}
+	var sensorGatherer prometheus.Gatherer = sensorReg
+	if *metricsCacheTTL > 0 {
+		// Shared by /metrics and /metrics-lite, so scrapes of both between them
+		// read the sensors at most once per TTL.
+		sensorGatherer = &cachingGatherer{gatherer: sensorReg, ttl: *metricsCacheTTL}
+	}
+	// authenticated requires --auth-user's credentials for h, if it was given.
+	authenticated := func(h http.Handler) http.Handler {
+		if *authUser == "" {
+			return h
+		}
+		return basicAuth(h, *authUser, *authPass)
+	}
+	metrics := authenticated(promhttp.HandlerFor(prometheus.Gatherers{reg, sensorGatherer}, promhttp.HandlerOpts{}))
+	metricsLite := authenticated(promhttp.HandlerFor(sensorGatherer, promhttp.HandlerOpts{}))
+	readingJSON := authenticated(collectors.handle((*mhz19Collector).readingJSON))
+	if *corsOrigin != "" {
+		readingJSON = allowOrigin(readingJSON, *corsOrigin)
+		if *corsMetrics {
+			// Outside basic auth, so preflights, which carry no credentials, succeed.
+			metrics = allowOrigin(metrics, *corsOrigin)
+			metricsLite = allowOrigin(metricsLite, *corsOrigin)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", authenticated(http.HandlerFunc(collectors.indexPage)))
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/metrics-lite", metricsLite)
+	mux.HandleFunc("/healthz", collectors.healthz)
+	mux.HandleFunc("/config", serveConfig)
+	mux.Handle("/reading.json", readingJSON)
+	if *debugMode {
+		mux.Handle("/debug/frame", authenticated(collectors.handle((*mhz19Collector).debugFrame)))
+	}
+	if *expvarEnabled {
+		expvar.Publish("mhz19", expvar.Func(collectors.vars))
+		mux.Handle("/debug/vars", authenticated(serveVars("mhz19")))
+	}
+	if *enableHumidity {
+		mux.Handle("/humidity", authenticated(collectors.handle((*mhz19Collector).postHumidity)))
+	}
+	if broadcast != nil {
+		mux.Handle("/stream", authenticated(http.HandlerFunc(broadcast.serveStream)))
+		mux.Handle("/events", authenticated(http.HandlerFunc(broadcast.serveEvents)))
+	}
+	if *enableCalibration {
+		mux.Handle("/calibrate/zero", authenticated(collectors.handle((*mhz19Collector).calibrateZero)))
+		mux.Handle("/calibrate/span", authenticated(collectors.handle((*mhz19Collector).calibrateSpan)))
+	} else {
+		mux.HandleFunc("/calibrate/", calibrationDisabled)
+	}
+
+	// The publishers read the sensors too, so they must stop before the
+	// sensors are closed.
+	var publishers sync.WaitGroup
+	startPublisher := func(publish func()) {
+		publishers.Add(1)
+		go func() {
+			defer publishers.Done()
+			publish()
+		}()
+	}
+	var mqttClient mqtt.Client
+	if *mqttBroker != "" {
+		var err error
+		if mqttClient, err = connectMQTT(*mqttBroker); err != nil {
+			fatal("connecting to MQTT broker failed", "broker", *mqttBroker, "error", err)
+		}
+		if *haDiscovery {
+			if err := publishHADiscovery(mqttClient, *mqttTopic, collectors); err != nil {
+				fatal("publishing Home Assistant discovery configs failed", "error", err)
+			}
+			slog.Info("published Home Assistant discovery configs")
+		}
+		startPublisher(func() { publishMQTT(ctx, mqttClient, *mqttTopic, *mqttInterval, collectors) })
+	}
 
-	http.ListenAndServe(*port, nil)
+	if *pushgatewayURL != "" {
+		startPublisher(func() { pushMetrics(ctx, *pushgatewayURL, *pollInterval, collectors) })
+	}
+	if *otlpEndpoint != "" {
+		startPublisher(func() { exportOTLP(ctx, *otlpEndpoint, *pollInterval, collectors) })
+	}
+	if *statsdAddr != "" {
+		startPublisher(func() {
+			sendStatsD(ctx, *statsdAddr, *statsdPrefix, *statsdFormat == "dogstatsd", *pollInterval, collectors)
+		})
+	}
+	if *influxURL != "" {
+		startPublisher(func() {
+			writeInflux(ctx, *influxURL, *influxOrg, *influxBucket, *influxToken, *pollInterval, collectors)
+		})
+	}
+
+	server := &http.Server{
+		Addr:         *port,
+		Handler:      mux,
+		ReadTimeout:  *httpReadTimeout,
+		WriteTimeout: *httpWriteTimeout,
+	}
+	if broadcast != nil {
+		server.RegisterOnShutdown(broadcast.shutdown)
+	}
+	listener, err := listen(*port)
+	if err != nil {
+		fatal("listening failed", "port", *port, "error", err)
+	}
+	notifyListening()
+	go func() {
+		var err error
+		if *tlsCert != "" {
+			err = server.ServeTLS(listener, *tlsCert, *tlsKey)
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != http.ErrServerClosed {
+			fatal("serving HTTP failed", "port", *port, "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("HTTP server shutdown failed", "error", err)
+	}
+	publishers.Wait()
+	if mqttClient != nil {
+		mqttClient.Disconnect(250)
+	}
+	pollers.Wait()
+	for _, collector := range collectors {
+		collector.close()
+	}
+	slog.Info("closed serial ports, exiting", "portnames", portnames.String())
 }
 
 type mhz19Collector struct {
-	mu         sync.Mutex // serial port is shared resource and this runs in HTTP handler goroutines
-	serialPort io.ReadWriter
+	mu               sync.Mutex         // guards the cached reading and counts, which HTTP handler goroutines read; never held while waiting for the sensor
+	portname         string             // identifies the sensor in labels and logs
+	sensor           Sensor             // reads the sensor, usually a *serialSensor
+	descs            *metricDescs       // of the metrics Collect sends
+	pollInterval     time.Duration      // 0 means read the sensor synchronously in Collect
+	pollJitter       float64            // fraction of pollInterval to randomly vary each poll by
+	minReadInterval  time.Duration      // reuse the cached reading rather than reading the sensor again this soon
+	readTimeout      time.Duration      // 0 means no limit beyond the serial port's InterCharacterTimeout
+	readRetries      int                // how many times to retry a read after a timeout, checksum error or short read
+	reads            singleflight.Group // shares on-demand reads between concurrent scrapes
+	firmware         string             // firmware version read at startup, if the sensor reported it
+	detectionRange   uint16             // ppm, read at startup or else set by --detection-range; 0 if unknown
+	startupOK        bool               // whether every startup step the sensor supports succeeded, guarded by mu
+	failureThreshold int                // consecutive failed reads before the sensor counts as not responding
+	readings         *readingsLog       // appends each reading to --log-readings-file, if not nil
+	broadcast        *broadcaster       // streams each reading to clients, if not nil
+
+	// The sensor needs a few minutes after power on before its readings settle.
+	started        time.Time // when the exporter started
+	warmup         time.Duration
+	warmupSuppress bool   // don't export CO2 until the warmup is over
+	minValidPPM    uint16 // don't export CO2 below this until the warmup is over
+
+	// Readings that changed by more than maxDelta ppm from the previous one are
+	// ignored as glitches, if maxDelta isn't 0.
+	maxDelta            uint
+	glitches            uint64 // guarded by mu
+	consecutiveGlitches int    // guarded by mu
+
+	// Corrections to the sensor's readings.
+	co2Warn    float64 // corrected ppm from which the air quality level is warn
+	co2Alert   float64 // corrected ppm from which the air quality level is alert
+	co2Offset  float64 // ppm added to the CO2 concentration after scaling it
+	co2Scale   float64
+	tempOffset float64 // degrees Celsius added to the sensor's temperature
+
+	// Optional metrics.
+	emitFahrenheit bool
+	emitRaw        bool
+	smoothing      *window // recent CO2 readings, guarded by mu; nil if smoothing is off
+
+	// Latest raw CO2 reading, guarded by mu. rawUnsupported is set once the
+	// sensor has left maxRawNoAnswers requests in a row unanswered without
+	// ever answering.
+	raw            uint16
+	rawOK          bool // raw is from the latest reading
+	rawSeen        bool // the sensor has answered a raw request at least once
+	rawNoAnswers   int  // consecutive raw requests the sensor didn't answer
+	rawUnsupported bool
+
+	// Relative humidity posted to /humidity, in percent, guarded by mu.
+	humidity     float64
+	humidityTime time.Time // zero if there hasn't been one
+
+	// Latest successful reading, guarded by mu. resp is nil until the first one.
+	resp     *gasConcentrationResponse
+	readTime time.Time
+
+	// The successful reading before that, guarded by mu, for the rate of change.
+	// prevResp is nil until the second one.
+	prevResp     *gasConcentrationResponse
+	prevReadTime time.Time
+
+	// CO2 concentration integrated over time, guarded by mu, for time-weighted
+	// averages of exposure.
+	ppmSeconds float64
+
+	// Error counts, guarded by mu.
+	checksumErrors uint64
+	readErrors     map[string]uint64 // by reason, see readErrorReason
+	parseErrors    map[string]uint64 // by kind, see parseErrorKind
+	retries        uint64
+	failures       int // consecutive failed reads since the last successful one
 }
 
+// readErrorReasons are the values of the reason label on the read errors metric.
+var readErrorReasons = []string{"timeout", "short_read", "checksum", "no_frame_start", "io"}
+
+// parseErrorKinds are the values of the kind label on the parse errors metric.
+var parseErrorKinds = []string{"bad_start_byte", "bad_command_echo", "checksum"}
+
+// parseErrorKind classifies an error reading a gas concentration response that
+// arrived but wasn't valid. ok is false for other errors.
+func parseErrorKind(err error) (kind string, ok bool) {
+	switch err {
+	case errBadStartByte:
+		return "bad_start_byte", true
+	case errBadCommandEcho:
+		return "bad_command_echo", true
+	}
+	if _, ok := err.(*checksumError); ok {
+		return "checksum", true
+	}
+	return "", false
+}
+
+// readErrorReason classifies an error reading a gas concentration response.
+func readErrorReason(err error) string {
+	var timeout interface{ Timeout() bool }
+	switch {
+	case err == io.EOF, err == context.DeadlineExceeded:
+		// The serial port returns no bytes at all once InterCharacterTimeout
+		// elapses, and the read may have passed its --read-timeout.
+		return "timeout"
+	case errors.As(err, &timeout) && timeout.Timeout():
+		// e.g. os.ErrDeadlineExceeded, or EAGAIN from a non-blocking port.
+		return "timeout"
+	case err == io.ErrUnexpectedEOF:
+		return "short_read"
+	case err == errBadStartByte, err == errBadCommandEcho:
+		return "no_frame_start"
+	}
+	switch err.(type) {
+	case *shortReadError:
+		return "short_read"
+	case *checksumError:
+		return "checksum"
+	}
+	return "io"
+}
+
+// retriable reports whether a read that failed for reason, from readErrorReason,
+// is worth retrying straight away: the sensor may have missed the request, or
+// line noise corrupted the response. Other errors reopen the serial port.
+func retriable(reason string) bool {
+	switch reason {
+	case "timeout", "checksum", "short_read", "no_frame_start":
+		return true
+	}
+	return false
+}
+
+// Describe sends the descriptors of every metric Collect may send, though which
+// it does depends on the readings so far, e.g. the rate of change needs two.
 func (c *mhz19Collector) Describe(ch chan<- *prometheus.Desc) {
-	prometheus.DescribeByCollect(c, ch)
+	c.descs.describe(ch)
 }
 
-func (c *mhz19Collector) Collect(ch chan<- prometheus.Metric) {
+// poll reads the sensor every pollInterval, varied by pollJitter, until ctx is done.
+func (c *mhz19Collector) poll(ctx context.Context) {
+	ticker := time.NewTicker(c.nextPoll())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.pollJitter > 0 {
+				ticker.Reset(c.nextPoll())
+			}
+			c.read()
+		}
+	}
+}
+
+// nextPoll returns how long to wait before the next poll.
+func (c *mhz19Collector) nextPoll() time.Duration {
+	return time.Duration(float64(c.pollInterval) * (1 + c.pollJitter*(2*rand.Float64()-1)))
+}
+
+// close releases the sensor, closing its serial port.
+func (c *mhz19Collector) close() {
+	c.sensor.Close()
+}
+
+// read requests a reading from the sensor and caches it for Collect, retrying
+// up to readRetries times after errors that might be transient, see retriable.
+// It does nothing if the cached reading is less than minReadInterval old. It
+// holds mu only to publish what it read, not while waiting for the sensor, so
+// scrapes of the cached reading don't wait for it.
+func (c *mhz19Collector) read() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	recent := c.resp != nil && time.Since(c.readTime) < c.minReadInterval
+	c.mu.Unlock()
+	if recent {
+		return // the cached reading is recent enough
+	}
 
-	err := mhz19.NewGasConcentrationRequest().Write(c.serialPort)
+	start := time.Now()
+	resp, err := c.request()
+	for attempt := 0; err != nil && attempt < c.readRetries; attempt++ {
+		if !retriable(readErrorReason(err)) {
+			break
+		}
+		c.mu.Lock()
+		c.retries++
+		c.mu.Unlock()
+		time.Sleep(retryDelay << attempt) // let the rest of the bad response arrive, so the next request discards it
+		start = time.Now()
+		resp, err = c.request()
+	}
 	if err != nil {
-		log.Fatalf("couldn't write to serial port: %v", err)
+		c.mu.Lock()
+		c.failures++
+		c.mu.Unlock()
+		return
+	}
+	readTime := time.Now()
+	if !c.publish(resp, readTime) {
+		return
+	}
+	notifySystemd()
+	if c.exportable(resp) {
+		r := c.readingOf(resp, readTime)
+		if c.broadcast != nil {
+			c.broadcast.publish(streamedReading{c.portname, r})
+		}
+		if c.readings != nil {
+			if err := c.readings.write(loggedReading{readTime, c.portname, r.CO2, r.Temperature}); err != nil {
+				slog.Warn("logging reading to --log-readings-file failed", "portname", c.portname, "error", err)
+			}
+		}
+	}
+	if c.atRangeLimit(resp) {
+		slog.Warn("CO2 concentration is at the sensor's detection range limit, so it may be higher", "portname", c.portname, "co2_ppm", resp.Concentration, "detection_range", c.detectionRange)
+	}
+	readDuration.WithLabelValues(c.portname).Observe(readTime.Sub(start).Seconds())
+	c.mu.Lock()
+	raw := c.emitRaw && !c.rawUnsupported
+	c.mu.Unlock()
+	if raw {
+		c.readRaw()
+	}
+}
+
+// publish caches a successful reading made at readTime for Collect, unless it
+// changed by more than maxDelta from the last one, as a glitch. It reports
+// whether it cached it.
+func (c *mhz19Collector) publish(resp *gasConcentrationResponse, readTime time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0 // the sensor did respond
+	if c.maxDelta > 0 && c.resp != nil && isGlitch(c.resp.Concentration, resp.Concentration, c.maxDelta) && c.consecutiveGlitches < maxConsecutiveGlitches {
+		slog.Warn("ignoring reading that changed by more than --max-delta", "portname", c.portname, "co2_ppm", resp.Concentration, "previous_co2_ppm", c.resp.Concentration)
+		c.glitches++
+		c.consecutiveGlitches++
+		return false
+	}
+	c.consecutiveGlitches = 0
+	slog.Debug("read sensor", "portname", c.portname, "co2_ppm", resp.Concentration, "temperature_celsius", resp.Temperature())
+	c.prevResp, c.prevReadTime = c.resp, c.readTime
+	c.resp, c.readTime = resp, readTime
+	if c.prevResp != nil && c.plausible(c.prevResp) {
+		// Assume the previous concentration held until this reading.
+		c.ppmSeconds += correctCO2(float64(c.prevResp.Concentration), c.co2Scale, c.co2Offset) * c.readTime.Sub(c.prevReadTime).Seconds()
+	}
+	if c.plausible(resp) {
+		if c.smoothing != nil {
+			c.smoothing.add(resp.Concentration)
+		}
+		co2Readings.WithLabelValues(c.portname).Observe(correctCO2(float64(resp.Concentration), c.co2Scale, c.co2Offset))
 	}
+	return true
+}
 
-	resp, err := mhz19.ReadGasConcentrationResponse(c.serialPort)
+// readRaw requests the raw CO2 concentration from the sensor. If the sensor has
+// never answered, and leaves maxRawNoAnswers requests in a row unanswered, it's
+// assumed not to support the request, which isn't sent again.
+func (c *mhz19Collector) readRaw() {
+	ctx := context.Background()
+	if c.readTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.readTimeout)
+		defer cancel()
+	}
+	raw, err := c.sensor.ReadRawCO2(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rawOK = false
+	if err != nil && isSendError(err) {
+		return
+	}
 	if err != nil {
-		if _, ok := err.(*mhz19.ChecksumError); ok {
-			log.Printf("checksum error: %v", err)
+		if unsupported(err) {
+			c.rawNoAnswers++
+		} else {
+			c.rawNoAnswers = 0
+		}
+		if !c.rawSeen && c.rawNoAnswers >= maxRawNoAnswers {
+			slog.Warn("sensor doesn't support reading raw CO2, not exporting it", "portname", c.portname, "error", err)
+			c.rawUnsupported = true
 			return
 		}
-		log.Printf("readGasConcentration error: %v", err)
+		slog.Debug("reading raw CO2 failed", "portname", c.portname, "error", err)
+		return
+	}
+	c.rawNoAnswers = 0
+	c.raw = raw
+	c.rawOK = true
+	c.rawSeen = true
+}
+
+// readShared is like read, but calls while one is in progress wait for it rather
+// than reading the sensor again, so bursts of scrapes share a single read.
+func (c *mhz19Collector) readShared() {
+	c.reads.Do(c.portname, func() (interface{}, error) {
+		c.read()
+		return nil, nil
+	})
+}
+
+// request sends a gas concentration request to the sensor and reads the
+// response, counting and logging any error. It holds mu only to count it.
+func (c *mhz19Collector) request() (*gasConcentrationResponse, error) {
+	ctx := context.Background()
+	if c.readTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.readTimeout)
+		defer cancel()
+	}
+	resp, err := c.sensor.ReadConcentration(ctx)
+	if err != nil && isSendError(err) {
+		return nil, err
+	}
+	if err != nil {
+		reason := readErrorReason(err)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.readErrors == nil {
+			c.readErrors = make(map[string]uint64)
+		}
+		c.readErrors[reason]++
+		if kind, ok := parseErrorKind(err); ok {
+			if c.parseErrors == nil {
+				c.parseErrors = make(map[string]uint64)
+			}
+			c.parseErrors[kind]++
+		}
+		if reason == "checksum" {
+			slog.Debug("checksum error", "portname", c.portname, "error", err)
+			c.checksumErrors++
+			return nil, err
+		}
+		slog.Error("readGasConcentration error", "portname", c.portname, "reason", reason, "error", err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *mhz19Collector) Collect(ch chan<- prometheus.Metric) {
+	if c.pollInterval == 0 {
+		c.readShared()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.up,
+		prometheus.GaugeValue,
+		boolToFloat(c.resp != nil && c.failures == 0),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.exporterInfo,
+		prometheus.GaugeValue,
+		1,
+		version,
+	)
+	stats, onSerial := c.serialStats()
+	if onSerial {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.serialConnected,
+			prometheus.GaugeValue,
+			boolToFloat(stats.connected),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.serialReconnectsTotal,
+			prometheus.CounterValue,
+			float64(stats.reconnects),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.serialBytesReadTotal,
+			prometheus.CounterValue,
+			float64(stats.bytesRead),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.serialBytesWrittenTotal,
+			prometheus.CounterValue,
+			float64(stats.bytesWritten),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.writeErrorsTotal,
+		prometheus.CounterValue,
+		float64(stats.writeErrors),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.checksumErrorsTotal,
+		prometheus.CounterValue,
+		float64(c.checksumErrors),
+	)
+	for _, reason := range readErrorReasons {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.readErrorsTotal,
+			prometheus.CounterValue,
+			float64(c.readErrors[reason]),
+			reason,
+		)
+	}
+
+	if c.firmware != "" {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.firmwareInfo,
+			prometheus.GaugeValue,
+			1,
+			c.firmware,
+		)
+	}
+
+	if c.detectionRange != 0 {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.detectionRangePPM,
+			prometheus.GaugeValue,
+			float64(c.detectionRange),
+		)
+	}
+
+	for _, kind := range parseErrorKinds {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.parseErrorsTotal,
+			prometheus.CounterValue,
+			float64(c.parseErrors[kind]),
+			kind,
+		)
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.glitchReadingsTotal,
+		prometheus.CounterValue,
+		float64(c.glitches),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.readRetriesTotal,
+		prometheus.CounterValue,
+		float64(c.retries),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.co2PPMSecondsTotal,
+		prometheus.CounterValue,
+		c.ppmSeconds,
+	)
+
+	lastSuccess := 0.0 // never
+	if c.resp != nil {
+		lastSuccess = float64(c.readTime.UnixNano()) / 1e9
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.lastSuccessTimestampSeconds,
+		prometheus.GaugeValue,
+		lastSuccess,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.exporterUptimeSeconds,
+		prometheus.GaugeValue,
+		time.Since(c.started).Seconds(),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.sensorResponding,
+		prometheus.GaugeValue,
+		boolToFloat(c.responding()),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.startupOK,
+		prometheus.GaugeValue,
+		boolToFloat(c.startupOK),
+	)
+
+	warmingUp := c.warmingUp()
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.warmingUp,
+		prometheus.GaugeValue,
+		boolToFloat(warmingUp),
+	)
+
+	resp := c.resp
+	if resp == nil {
 		return
 	}
+	slog.Debug("collecting reading", "portname", c.portname, "response", resp.String(), "read_time", c.readTime)
+	if c.exportable(resp) {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.co2ConcentrationPPM,
+			prometheus.GaugeValue,
+			correctCO2(float64(resp.Concentration), c.co2Scale, c.co2Offset),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.co2ConcentrationPPMRaw,
+			prometheus.GaugeValue,
+			float64(resp.Concentration),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.airQualityLevel,
+			prometheus.GaugeValue,
+			float64(airQualityLevel(correctCO2(float64(resp.Concentration), c.co2Scale, c.co2Offset), c.co2Warn, c.co2Alert)),
+		)
+		if c.detectionRange != 0 {
+			ch <- prometheus.MustNewConstMetric(
+				c.descs.co2AtRangeLimit,
+				prometheus.GaugeValue,
+				boolToFloat(c.atRangeLimit(resp)),
+			)
+		}
+		if c.prevResp != nil && c.prevResp.Concentration >= c.minValidPPM {
+			minutes := c.readTime.Sub(c.prevReadTime).Minutes()
+			ch <- prometheus.MustNewConstMetric(
+				c.descs.co2PPMPerMinute,
+				prometheus.GaugeValue,
+				c.co2Scale*(float64(resp.Concentration)-float64(c.prevResp.Concentration))/minutes,
+			)
+		}
+		if c.smoothing != nil {
+			ch <- prometheus.MustNewConstMetric(
+				c.descs.co2ConcentrationPPMSmoothed,
+				prometheus.GaugeValue,
+				correctCO2(c.smoothing.mean(), c.co2Scale, c.co2Offset),
+			)
+		}
+	}
+	if c.rawOK && (!warmingUp || !c.warmupSuppress) {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.co2RawPPM,
+			prometheus.GaugeValue,
+			float64(c.raw),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.descs.sensorStatus,
+		prometheus.GaugeValue,
+		float64(resp.Status()),
+	)
+	temperature := offsetTemperature(resp.Temperature(), c.tempOffset)
 	ch <- prometheus.MustNewConstMetric(
-		prometheus.NewDesc(
-			prefix+"_co2_concentration_ppm",
-			"Carbon Dioxide Concentration in parts per million",
-			[]string{},
-			nil),
+		c.descs.temperatureCelsius,
 		prometheus.GaugeValue,
-		float64(resp.Concentration),
+		temperature,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		prometheus.NewDesc(
-			prefix+"_temperature_celsius",
-			"Sensor Temperature in degrees Celsius",
-			[]string{},
-			nil),
+		c.descs.temperatureRawCelsius,
 		prometheus.GaugeValue,
 		float64(resp.Temperature()),
 	)
+	if rh, ok := c.recentHumidity(); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.dewPointCelsius,
+			prometheus.GaugeValue,
+			dewPoint(temperature, rh),
+		)
+	}
+	if c.emitFahrenheit {
+		ch <- prometheus.MustNewConstMetric(
+			c.descs.temperatureFahrenheit,
+			prometheus.GaugeValue,
+			celsiusToFahrenheit(temperature),
+		)
+	}
+}
+
+// serialStats returns the counts of the sensor's serial port activity. ok is
+// false, and the counts zero, if the sensor isn't on a serial port.
+func (c *mhz19Collector) serialStats() (stats serialStats, ok bool) {
+	s, ok := c.sensor.(interface{ serialStats() serialStats })
+	if !ok {
+		return serialStats{}, false
+	}
+	return s.serialStats(), true
+}
+
+// responding reports whether the sensor has been read successfully and the
+// reads since haven't failed failureThreshold times in a row.
+// Must be called with mu held.
+func (c *mhz19Collector) responding() bool {
+	return c.resp != nil && c.failures < c.failureThreshold
+}
+
+// plausible reports whether a reading's CO2 concentration is worth exporting:
+// while warming up, the sensor reports 0 or implausibly low concentrations.
+func (c *mhz19Collector) plausible(resp *gasConcentrationResponse) bool {
+	return !c.warmingUp() || resp.Concentration >= c.minValidPPM
+}
+
+// exportable reports whether a reading's CO2 concentration should be exported,
+// by the metrics and every other output: readings from a sensor that's still
+// warming up can be wildly off, so not then with --warmup-suppress, and never
+// if they're implausible.
+func (c *mhz19Collector) exportable(resp *gasConcentrationResponse) bool {
+	return (!c.warmingUp() || !c.warmupSuppress) && c.plausible(resp)
+}
+
+// atRangeLimit reports whether resp is at or over the sensor's detection range,
+// where its readings stop rising. It's false if the range isn't known.
+func (c *mhz19Collector) atRangeLimit(resp *gasConcentrationResponse) bool {
+	return c.detectionRange != 0 && resp.Concentration >= c.detectionRange
+}
+
+// warmingUp reports whether the sensor may still be warming up.
+func (c *mhz19Collector) warmingUp() bool {
+	return time.Since(c.started) < c.warmup
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// airQualityLevel returns 0 (good) for a CO2 concentration in ppm below warn,
+// 2 (alert) for one at or above alert, and 1 (warn) in between.
+func airQualityLevel(ppm, warn, alert float64) int {
+	switch {
+	case ppm >= alert:
+		return 2
+	case ppm >= warn:
+		return 1
+	}
+	return 0
+}
+
+// isGlitch reports whether a CO2 concentration read after prev differs from it
+// by more than maxDelta ppm, which the air can't do between readings, so it's
+// more likely a bit error that the checksum missed.
+func isGlitch(prev, ppm uint16, maxDelta uint) bool {
+	delta := int(ppm) - int(prev)
+	if delta < 0 {
+		delta = -delta
+	}
+	return uint(delta) > maxDelta
+}
+
+// correctCO2 corrects a CO2 concentration reported by the sensor, in ppm, by
+// multiplying it by scale and adding offset.
+func correctCO2(ppm, scale, offset float64) float64 {
+	return scale*ppm + offset
+}
+
+// offsetTemperature corrects a temperature reported by the sensor by adding offset.
+func offsetTemperature(celsius int, offset float64) float64 {
+	return float64(celsius) + offset
+}
+
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
 }
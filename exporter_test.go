@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMain(m *testing.M) {
+	createHistograms()
+	os.Exit(m.Run())
+}
+
+// fakePort is a serial port whose sensor answers each request written to it with
+// the next of responses, for testing how the collector handles what it reads.
+type fakePort struct {
+	mu        sync.Mutex
+	responses [][]byte      // to each request in turn; nil for no response
+	writeErr  error         // returned by every write, if not nil
+	readErr   error         // returned by reads once a response runs out, rather than io.EOF
+	delay     time.Duration // how long the sensor takes to answer
+	release   chan struct{} // if not nil, writes wait until it's closed
+
+	pending []byte // rest of the current response
+	closed  bool
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writeErr != nil {
+		return 0, p.writeErr
+	}
+	if p.release != nil {
+		<-p.release
+	}
+	time.Sleep(p.delay)
+	p.pending = nil
+	if len(p.responses) > 0 {
+		p.pending = p.responses[0]
+		p.responses = p.responses[1:]
+	}
+	return len(b), nil
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) == 0 {
+		if p.readErr != nil {
+			return 0, p.readErr
+		}
+		return 0, io.EOF
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *fakePort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// errUnplugged is returned by the test collectors' open, so that a
+// disconnected fake port stays disconnected.
+var errUnplugged = errors.New("unplugged")
+
+// newTestCollector returns a collector for a sensor on serialPort, polled in
+// the background, with the default flags except that it doesn't retry reads
+// or warm up. The port can't be reopened once it's closed.
+func newTestCollector(t *testing.T, serialPort io.ReadWriteCloser) *mhz19Collector {
+	t.Helper()
+	name := t.Name()
+	open := func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return nil, errUnplugged
+	}
+	return &mhz19Collector{
+		portname:         name,
+		sensor:           newSerialSensor(serial.OpenOptions{PortName: name}, open, serialPort),
+		descs:            newMetricDescs(name),
+		pollInterval:     5 * time.Second,
+		readTimeout:      3 * time.Second,
+		failureThreshold: 3,
+		started:          time.Now(),
+		minValidPPM:      1,
+		co2Warn:          1000,
+		co2Alert:         1500,
+		co2Scale:         1,
+	}
+}
+
+// gather collects c's metrics with a pedantic registry, failing the test if
+// they're inconsistent.
+func gather(t *testing.T, c prometheus.Collector) []*dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gathering: %v", err)
+	}
+	return families
+}
+
+// metricValue returns the value of the counter or gauge named name that c
+// collects, with the given label name and value pairs, failing the test if
+// there isn't one.
+func metricValue(t *testing.T, c prometheus.Collector, name string, labels ...string) float64 {
+	t.Helper()
+	for _, f := range gather(t, c) {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			values := map[string]string{}
+			for _, l := range m.GetLabel() {
+				values[l.GetName()] = l.GetValue()
+			}
+			for i := 0; i+1 < len(labels); i += 2 {
+				if values[labels[i]] != labels[i+1] {
+					continue metrics
+				}
+			}
+			if m.GetCounter() != nil {
+				return m.GetCounter().GetValue()
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("no metric %v%v collected", name, labels)
+	return 0
+}
+
+// collected reports whether c collects a metric named name.
+func collected(t *testing.T, c prometheus.Collector, name string) bool {
+	t.Helper()
+	for _, f := range gather(t, c) {
+		if f.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCollect(t *testing.T) {
+	c := newTestCollector(t, &fakePort{responses: [][]byte{hexBytes(t, goodFrame)}})
+	c.read()
+	port := t.Name()
+	expected := `
+# HELP mhz19_co2_concentration_ppm Carbon Dioxide Concentration in parts per million, read with command 0x86, from 0 up to the detection range, corrected by --co2-scale and --co2-offset
+# TYPE mhz19_co2_concentration_ppm gauge
+mhz19_co2_concentration_ppm{port="` + port + `"} 450
+# HELP mhz19_temperature_celsius Sensor Temperature in degrees Celsius, read with command 0x86, corrected by --temp-offset. It's the temperature inside the sensor, which runs a few degrees above the air
+# TYPE mhz19_temperature_celsius gauge
+mhz19_temperature_celsius{port="` + port + `"} 25
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "mhz19_co2_concentration_ppm", "mhz19_temperature_celsius"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectChecksumError(t *testing.T) {
+	frame := hexBytes(t, goodFrame)
+	frame[8]++
+	c := newTestCollector(t, &fakePort{responses: [][]byte{frame}})
+	c.read()
+	if collected(t, c, "mhz19_co2_concentration_ppm") {
+		t.Error("exported the CO2 concentration of a frame with a bad checksum")
+	}
+	if got := metricValue(t, c, "mhz19_checksum_errors_total"); got != 1 {
+		t.Errorf("checksum_errors_total = %v, want 1", got)
+	}
+}
+
+func TestCollectWriteError(t *testing.T) {
+	c := newTestCollector(t, &fakePort{writeErr: errors.New("input/output error")})
+	c.read()
+	if collected(t, c, "mhz19_co2_concentration_ppm") {
+		t.Error("exported a CO2 concentration without writing a request")
+	}
+	if got := metricValue(t, c, "mhz19_write_errors_total"); got != 1 {
+		t.Errorf("write_errors_total = %v, want 1", got)
+	}
+	if got := metricValue(t, c, "mhz19_serial_connected"); got != 0 {
+		t.Errorf("serial_connected = %v, want 0 after a failed write", got)
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		kind   string // "" if it isn't a parse error
+		reason string
+	}{
+		{name: "bad start byte", err: errBadStartByte, kind: "bad_start_byte", reason: "no_frame_start"},
+		{name: "bad command echo", err: errBadCommandEcho, kind: "bad_command_echo", reason: "no_frame_start"},
+		{name: "checksum", err: &checksumError{got: 0x77, want: 0x76}, kind: "checksum", reason: "checksum"},
+		{name: "short read", err: &shortReadError{n: 4}, reason: "short_read"},
+		{name: "no response", err: io.EOF, reason: "timeout"},
+		{name: "io", err: errors.New("input/output error"), reason: "io"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kind, ok := parseErrorKind(tc.err)
+			if kind != tc.kind || ok != (tc.kind != "") {
+				t.Errorf("parseErrorKind = %q, %v, want %q", kind, ok, tc.kind)
+			}
+			if reason := readErrorReason(tc.err); reason != tc.reason {
+				t.Errorf("readErrorReason = %q, want %q", reason, tc.reason)
+			}
+		})
+	}
+}
+
+func TestCollectSharesRead(t *testing.T) {
+	release := make(chan struct{})
+	port := &fakePort{responses: [][]byte{hexBytes(t, goodFrame)}, release: release}
+	c := newTestCollector(t, port)
+	c.pollInterval = 0 // read on every scrape
+	const scrapes = 10
+	var wg sync.WaitGroup
+	for i := 0; i < scrapes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testutil.CollectAndCount(c)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // for the scrapes to all wait on the first one's read
+	close(release)
+	wg.Wait()
+	if got := c.sensor.(*serialSensor).serialStats().bytesWritten; got != 9 {
+		t.Errorf("%d concurrent scrapes wrote %d bytes, want the 9 of a single request", scrapes, got)
+	}
+	if _, ok := c.cached(); !ok {
+		t.Error("no reading cached")
+	}
+}
+
+func TestWriteErrorDisconnects(t *testing.T) {
+	port := &fakePort{writeErr: errors.New("input/output error")}
+	c := newTestCollector(t, port)
+	c.read()
+	if got := metricValue(t, c, "mhz19_write_errors_total"); got != 1 {
+		t.Errorf("write_errors_total = %v, want 1", got)
+	}
+	if got := metricValue(t, c, "mhz19_serial_connected"); got != 0 {
+		t.Errorf("serial_connected = %v, want 0", got)
+	}
+	if !port.closed {
+		t.Error("serial port left open after a write to it failed")
+	}
+	// While the port can't be reopened, reads fail without writing to it.
+	c.read()
+	if got := metricValue(t, c, "mhz19_write_errors_total"); got != 1 {
+		t.Errorf("write_errors_total after reading again = %v, want 1", got)
+	}
+	if got := metricValue(t, c, "mhz19_up"); got != 0 {
+		t.Errorf("up = %v, want 0", got)
+	}
+}
+
+func TestChecksumErrorCounted(t *testing.T) {
+	frame := hexBytes(t, goodFrame)
+	frame[8]++
+	c := newTestCollector(t, &fakePort{responses: [][]byte{frame}})
+	c.read()
+	if got := metricValue(t, c, "mhz19_checksum_errors_total"); got != 1 {
+		t.Errorf("checksum_errors_total = %v, want 1", got)
+	}
+	if got := metricValue(t, c, "mhz19_read_errors_total", "reason", "checksum"); got != 1 {
+		t.Errorf("read_errors_total{reason=checksum} = %v, want 1", got)
+	}
+	if got := metricValue(t, c, "mhz19_parse_errors_total", "kind", "checksum"); got != 1 {
+		t.Errorf("parse_errors_total{kind=checksum} = %v, want 1", got)
+	}
+}
+
+func TestSensorRespondingThreshold(t *testing.T) {
+	good := hexBytes(t, goodFrame)
+	port := &fakePort{responses: [][]byte{good, nil, nil, nil, good}}
+	c := newTestCollector(t, port)
+	c.failureThreshold = 3
+	for i, want := range []float64{
+		1, // read
+		1, // one failure
+		1, // two
+		0, // three, the threshold
+		1, // read again
+	} {
+		c.read()
+		if got := metricValue(t, c, "mhz19_sensor_responding"); got != want {
+			t.Errorf("after read %d, sensor_responding = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestPollStopsWhenCancelled(t *testing.T) {
+	mock, _ := openMock(false)(serial.OpenOptions{})
+	c := newTestCollector(t, mock)
+	c.pollInterval = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.poll(ctx)
+		close(done)
+	}()
+	time.Sleep(3 * c.pollInterval) // to poll a few times
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(c.pollInterval):
+		t.Fatal("poll didn't return within a poll interval of its context being cancelled")
+	}
+	if _, ok := c.cached(); !ok {
+		t.Error("poll didn't read the sensor")
+	}
+}
+
+func TestReadDurationObserved(t *testing.T) {
+	port := &fakePort{responses: [][]byte{hexBytes(t, goodFrame)}, delay: 30 * time.Millisecond}
+	c := newTestCollector(t, port)
+	readDuration.DeleteLabelValues(c.portname) // from earlier runs, e.g. with -count
+	c.read()
+	var m dto.Metric
+	if err := readDuration.WithLabelValues(c.portname).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetHistogram().GetSampleCount(); got != 1 {
+		t.Fatalf("read_duration_seconds count = %d, want 1", got)
+	}
+	// 30ms is in the 50ms bucket, and those above it, but not the 25ms one.
+	for _, b := range m.GetHistogram().GetBucket() {
+		want := uint64(0)
+		if b.GetUpperBound() >= 0.05 {
+			want = 1
+		}
+		if got := b.GetCumulativeCount(); got != want {
+			t.Errorf("read_duration_seconds_bucket{le=%v} = %d, want %d", b.GetUpperBound(), got, want)
+		}
+	}
+}
+
+// timeoutError is a read error like the os.ErrDeadlineExceeded from a serial
+// port read that passed its deadline.
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+func TestTimeoutRetriedWithoutDisconnecting(t *testing.T) {
+	port := &fakePort{responses: [][]byte{nil, hexBytes(t, goodFrame)}, readErr: timeoutError{}}
+	c := newTestCollector(t, port)
+	c.readRetries = 1
+	c.read()
+	if got := metricValue(t, c, "mhz19_read_errors_total", "reason", "timeout"); got != 1 {
+		t.Errorf("read_errors_total{reason=timeout} = %v, want 1", got)
+	}
+	if got := metricValue(t, c, "mhz19_read_errors_total", "reason", "io"); got != 0 {
+		t.Errorf("read_errors_total{reason=io} = %v, want 0", got)
+	}
+	if got := metricValue(t, c, "mhz19_read_retries_total"); got != 1 {
+		t.Errorf("read_retries_total = %v, want 1", got)
+	}
+	if got := metricValue(t, c, "mhz19_up"); got != 1 {
+		t.Errorf("up = %v, want 1 after the retry", got)
+	}
+	if got := metricValue(t, c, "mhz19_serial_connected"); got != 1 || port.closed {
+		t.Errorf("serial_connected = %v, closed = %v, want the port left open", got, port.closed)
+	}
+}
+
+func TestCollectSkipsLeadingNoise(t *testing.T) {
+	port := &fakePort{responses: [][]byte{hexBytes(t, "00 3f ff "+goodFrame)}}
+	c := newTestCollector(t, port)
+	c.read()
+	if got := metricValue(t, c, "mhz19_co2_concentration_ppm"); got != 450 {
+		t.Errorf("co2_concentration_ppm = %v, want 450", got)
+	}
+	for _, reason := range readErrorReasons {
+		if got := metricValue(t, c, "mhz19_read_errors_total", "reason", reason); got != 0 {
+			t.Errorf("read_errors_total{reason=%v} = %v, want 0", reason, got)
+		}
+	}
+}
+
+func TestPreheatReadingNotExported(t *testing.T) {
+	preheat := "ff 86 00 00 41 00 00 00 39" // 0ppm, 25°C
+	c := newTestCollector(t, &fakePort{responses: [][]byte{hexBytes(t, preheat), hexBytes(t, goodFrame)}})
+	c.warmup = time.Minute
+	c.read()
+	if r, ok := c.cached(); ok {
+		t.Errorf("cached() = %v, want no reading while the sensor reports 0ppm warming up", r)
+	}
+	if collected(t, c, "mhz19_co2_concentration_ppm") {
+		t.Error("exported the 0ppm CO2 concentration")
+	}
+	if got := metricValue(t, c, "mhz19_warming_up"); got != 1 {
+		t.Errorf("warming_up = %v, want 1", got)
+	}
+	c.read()
+	if r, ok := c.cached(); !ok || r.CO2 != 450 {
+		t.Errorf("cached() = %v, %v, want 450ppm once the sensor reports it", r, ok)
+	}
+}
+
+func TestIsGlitch(t *testing.T) {
+	for _, tc := range []struct {
+		prev, ppm uint16
+		maxDelta  uint
+		want      bool
+	}{
+		{prev: 500, ppm: 500, maxDelta: 100, want: false},
+		{prev: 500, ppm: 600, maxDelta: 100, want: false},
+		{prev: 500, ppm: 601, maxDelta: 100, want: true},
+		{prev: 500, ppm: 400, maxDelta: 100, want: false},
+		{prev: 500, ppm: 399, maxDelta: 100, want: true},
+		{prev: 5000, ppm: 0, maxDelta: 4999, want: true},
+	} {
+		if got := isGlitch(tc.prev, tc.ppm, tc.maxDelta); got != tc.want {
+			t.Errorf("isGlitch(%d, %d, %d) = %v, want %v", tc.prev, tc.ppm, tc.maxDelta, got, tc.want)
+		}
+	}
+}
+
+func TestGlitchesIgnoredUntilTooManyInARow(t *testing.T) {
+	c := newTestCollector(t, &fakePort{})
+	c.maxDelta = 100
+	ppm := func(concentration uint16) *gasConcentrationResponse {
+		resp := &gasConcentrationResponse{}
+		resp.Concentration, resp.OffsetTemperature = concentration, 25+40
+		return resp
+	}
+	if !c.publish(ppm(500), time.Now()) {
+		t.Fatal("first reading ignored")
+	}
+	for i := 1; i <= maxConsecutiveGlitches; i++ {
+		if c.publish(ppm(5000), time.Now()) {
+			t.Fatalf("glitch %d of %d in a row accepted", i, maxConsecutiveGlitches)
+		}
+	}
+	if !c.publish(ppm(5000), time.Now()) {
+		t.Fatalf("reading after %d glitches in a row ignored", maxConsecutiveGlitches)
+	}
+	if got := metricValue(t, c, "mhz19_co2_concentration_ppm"); got != 5000 {
+		t.Errorf("co2_concentration_ppm = %v, want the accepted 5000", got)
+	}
+	if got := metricValue(t, c, "mhz19_glitch_readings_total"); got != maxConsecutiveGlitches {
+		t.Errorf("glitch_readings_total = %v, want %d", got, maxConsecutiveGlitches)
+	}
+	// The count starts again after an accepted reading.
+	if c.publish(ppm(500), time.Now()) {
+		t.Error("glitch after an accepted reading accepted")
+	}
+}
+
+func TestAirQualityLevel(t *testing.T) {
+	for _, tc := range []struct {
+		ppm  float64
+		want int
+	}{
+		{ppm: 400, want: 0},
+		{ppm: 999.9, want: 0},
+		{ppm: 1000, want: 1},
+		{ppm: 1499, want: 1},
+		{ppm: 1500, want: 2},
+		{ppm: 5000, want: 2},
+	} {
+		if got := airQualityLevel(tc.ppm, 1000, 1500); got != tc.want {
+			t.Errorf("airQualityLevel(%v, 1000, 1500) = %d, want %d", tc.ppm, got, tc.want)
+		}
+	}
+}
+
+func TestCelsiusToFahrenheit(t *testing.T) {
+	for _, tc := range []struct{ celsius, want float64 }{
+		{celsius: -40, want: -40},
+		{celsius: 0, want: 32},
+		{celsius: 25, want: 77},
+		{celsius: 100, want: 212},
+	} {
+		if got := celsiusToFahrenheit(tc.celsius); got != tc.want {
+			t.Errorf("celsiusToFahrenheit(%v) = %v, want %v", tc.celsius, got, tc.want)
+		}
+	}
+}
+
+func TestCorrectCO2(t *testing.T) {
+	for _, tc := range []struct{ ppm, scale, offset, want float64 }{
+		{ppm: 450, scale: 1, offset: 0, want: 450},
+		{ppm: 450, scale: 1, offset: -50, want: 400},
+		{ppm: 500, scale: 1.5, offset: 0, want: 750},
+		{ppm: 500, scale: 0.5, offset: 10, want: 260},
+	} {
+		if got := correctCO2(tc.ppm, tc.scale, tc.offset); got != tc.want {
+			t.Errorf("correctCO2(%v, %v, %v) = %v, want %v", tc.ppm, tc.scale, tc.offset, got, tc.want)
+		}
+	}
+}
+
+func TestOffsetTemperature(t *testing.T) {
+	for _, tc := range []struct {
+		celsius      int
+		offset, want float64
+	}{
+		{celsius: 25, offset: 0, want: 25},
+		{celsius: 25, offset: -3, want: 22},
+		{celsius: 25, offset: -2.5, want: 22.5},
+		{celsius: -5, offset: 1, want: -4},
+	} {
+		if got := offsetTemperature(tc.celsius, tc.offset); got != tc.want {
+			t.Errorf("offsetTemperature(%d, %v) = %v, want %v", tc.celsius, tc.offset, got, tc.want)
+		}
+	}
+}
+
+func TestRawUnsupportedAfterNoAnswers(t *testing.T) {
+	good := hexBytes(t, goodFrame)
+	var responses [][]byte
+	for i := 0; i < maxRawNoAnswers; i++ {
+		responses = append(responses, good, nil) // answers 0x86, but not 0x85
+	}
+	c := newTestCollector(t, &fakePort{responses: responses})
+	c.emitRaw = true
+	for i := 1; i <= maxRawNoAnswers; i++ {
+		c.read()
+		if want := i == maxRawNoAnswers; c.rawUnsupported != want {
+			t.Errorf("after %d unanswered raw requests, rawUnsupported = %v, want %v", i, c.rawUnsupported, want)
+		}
+	}
+}
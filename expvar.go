@@ -0,0 +1,72 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sensorVars is a sensor's state as published by --expvar.
+type sensorVars struct {
+	CO2            *float64          `json:"co2_ppm,omitempty"`
+	Temperature    *float64          `json:"temperature_celsius,omitempty"`
+	LastSuccess    *time.Time        `json:"last_success,omitempty"`
+	ReadErrors     map[string]uint64 `json:"read_errors"`
+	ChecksumErrors uint64            `json:"checksum_errors"`
+	WriteErrors    uint64            `json:"write_errors"`
+	Reconnects     uint64            `json:"reconnects"`
+}
+
+// vars returns the sensor's latest reading and error counts, without reading it.
+func (c *mhz19Collector) vars() sensorVars {
+	stats, _ := c.serialStats()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := sensorVars{
+		ReadErrors:     make(map[string]uint64),
+		ChecksumErrors: c.checksumErrors,
+		WriteErrors:    stats.writeErrors,
+		Reconnects:     stats.reconnects,
+	}
+	for _, reason := range readErrorReasons {
+		v.ReadErrors[reason] = c.readErrors[reason]
+	}
+	if c.resp != nil && c.exportable(c.resp) {
+		r := c.readingOf(c.resp, c.readTime)
+		v.CO2, v.Temperature, v.LastSuccess = &r.CO2, &r.Temperature, &r.Time
+	}
+	return v
+}
+
+// vars returns each sensor's vars by portname, for expvar.Func.
+func (s sensors) vars() any {
+	m := make(map[string]sensorVars, len(s))
+	for _, c := range s {
+		m[c.portname] = c.vars()
+	}
+	return m
+}
+
+// serveVars returns a handler for GET /debug/vars like expvar.Handler, but
+// serving only the given vars. expvar.Handler also serves cmdline, which would
+// give away secrets passed as flags, like --auth-pass.
+func serveVars(names ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, "{\n")
+		first := true
+		for _, name := range names {
+			v := expvar.Get(name)
+			if v == nil {
+				continue
+			}
+			if !first {
+				fmt.Fprint(w, ",\n")
+			}
+			first = false
+			fmt.Fprintf(w, "%q: %s", name, v)
+		}
+		fmt.Fprint(w, "\n}\n")
+	})
+}
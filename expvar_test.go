@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeVars(t *testing.T) {
+	if expvar.Get("test_served") == nil {
+		expvar.NewInt("test_served").Set(42)
+	}
+	w := httptest.NewRecorder()
+	serveVars("test_served", "not_published").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	var vars map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("unmarshalling %q: %v", w.Body.String(), err)
+	}
+	if len(vars) != 1 || vars["test_served"] != 42.0 {
+		t.Errorf("served %v, want only test_served: 42", vars)
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Error("served cmdline, which has any secrets given as flags")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io"
+
+	"golang.org/x/sys/unix"
+)
+
+// flushInput discards any bytes the serial port has received but that haven't
+// been read yet.
+func flushInput(serialPort io.Reader) error {
+	fd, ok := fileDescriptor(serialPort)
+	if !ok {
+		return nil
+	}
+	return unix.IoctlSetInt(int(fd), unix.TCFLSH, unix.TCIFLUSH)
+}
+
+// fileDescriptor returns the file descriptor of serialPort, unwrapping it from
+// e.g. a countingPort first. ok is false if it isn't a file, e.g. a mockPort.
+func fileDescriptor(serialPort io.Reader) (fd uintptr, ok bool) {
+	for {
+		w, ok := serialPort.(interface{ Unwrap() io.ReadWriteCloser })
+		if !ok {
+			break
+		}
+		serialPort = w.Unwrap()
+	}
+	f, ok := serialPort.(interface{ Fd() uintptr })
+	if !ok {
+		return 0, false
+	}
+	return f.Fd(), true
+}
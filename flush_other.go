@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "io"
+
+// flushInput is only implemented on Linux. Elsewhere, stale bytes are left for
+// the next read to trip over.
+func flushInput(serialPort io.Reader) error {
+	return nil
+}
@@ -1,10 +1,26 @@
 module github.com/mhansen/mhz19-exporter
 
-go 1.16
+go 1.21
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/gorilla/websocket v1.5.0
 	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
 	github.com/mhansen/mhz19 v0.0.0-20210402044919-ab5705aaf3a1
 	github.com/prometheus/client_golang v1.10.0
-	golang.org/x/sys v0.0.0-20210331175145-43e1dd70ce54 // indirect
+	github.com/prometheus/client_model v0.2.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/common v0.18.0 // indirect
+	github.com/prometheus/procfs v0.6.0 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	google.golang.org/protobuf v1.23.0 // indirect
 )
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// healthz handles GET /healthz, responding 200 if every sensor has given a valid
+// reading recently and 503 otherwise.
+func (s sensors) healthz(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	healthy := true
+	for _, c := range s {
+		if _, err := c.freshReading(); err != nil {
+			healthy = false
+			fmt.Fprintf(&b, "%v: %v\n", c.portname, err)
+		} else {
+			fmt.Fprintf(&b, "%v: ok\n", c.portname)
+		}
+	}
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprint(w, b.String())
+}
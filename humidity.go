@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// humidityMaxAge is how long a relative humidity posted to /humidity is used for.
+// A companion sensor that stops posting shouldn't leave a stale dew point behind.
+const humidityMaxAge = 10 * time.Minute
+
+// postHumidity handles POST /humidity?rh=N, recording the relative humidity in
+// percent next to the sensor, e.g. from a companion DHT22 or SHT31, for the dew point.
+func (c *mhz19Collector) postHumidity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "humidity must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	rh, err := strconv.ParseFloat(r.URL.Query().Get("rh"), 64)
+	if err != nil || rh <= 0 || rh > 100 {
+		http.Error(w, "rh must be a relative humidity above 0 and up to 100 percent", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.humidity = rh
+	c.humidityTime = time.Now()
+	c.mu.Unlock()
+	slog.Debug("humidity posted", "portname", c.portname, "rh", rh)
+	fmt.Fprintf(w, "humidity %v%% recorded\n", rh)
+}
+
+// recentHumidity returns the relative humidity last posted, unless it's older
+// than humidityMaxAge or there hasn't been one. Must be called with mu held.
+func (c *mhz19Collector) recentHumidity() (rh float64, ok bool) {
+	if c.humidityTime.IsZero() || time.Since(c.humidityTime) > humidityMaxAge {
+		return 0, false
+	}
+	return c.humidity, true
+}
+
+// dewPoint returns the dew point in degrees Celsius of air at a temperature in
+// degrees Celsius and a relative humidity in percent, using the Magnus formula
+// with the Sonntag (1990) constants, good to about 0.35°C from -45 to 60°C.
+func dewPoint(celsius, rh float64) float64 {
+	const a, b = 17.62, 243.12
+	gamma := math.Log(rh/100) + a*celsius/(b+celsius)
+	return b * gamma / (a - gamma)
+}
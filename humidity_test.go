@@ -0,0 +1,21 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDewPoint(t *testing.T) {
+	// Within the formula's 0.35°C of published dew point tables.
+	for _, tc := range []struct{ celsius, rh, want float64 }{
+		{celsius: 20, rh: 100, want: 20},
+		{celsius: 20, rh: 50, want: 9.3},
+		{celsius: 25, rh: 60, want: 16.7},
+		{celsius: 30, rh: 80, want: 26.2},
+		{celsius: 0, rh: 50, want: -9.2},
+	} {
+		if got := dewPoint(tc.celsius, tc.rh); math.Abs(got-tc.want) > 0.35 {
+			t.Errorf("dewPoint(%v, %v) = %.2f, want %v", tc.celsius, tc.rh, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// indexData is what the index page shows.
+type indexData struct {
+	Portnames string
+	Sensors   []indexSensor
+}
+
+// indexSensor is a sensor's latest reading, for the index page.
+type indexSensor struct {
+	Port    string
+	OK      bool // false if there hasn't been a reading yet
+	Reading reading
+	Age     time.Duration
+}
+
+// indexPage handles GET / with a page showing each sensor's latest cached reading.
+func (s sensors) indexPage(w http.ResponseWriter, r *http.Request) {
+	data := indexData{Portnames: portnames.String()}
+	for _, c := range s {
+		reading, ok := c.cached()
+		data.Sensors = append(data.Sensors, indexSensor{
+			Port:    c.portname,
+			OK:      ok,
+			Reading: reading,
+			Age:     time.Since(reading.Time).Round(time.Second),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	index.Execute(w, data)
+}
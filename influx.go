@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// influxClient writes to InfluxDB.
+var influxClient = &http.Client{Timeout: 10 * time.Second}
+
+// influxTagEscaper escapes InfluxDB line protocol tag values.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// writeInflux writes each sensor's latest reading to an InfluxDB 2 bucket every
+// interval, until ctx is done, as points of the mhz19 measurement tagged with
+// the serial port.
+func writeInflux(ctx context.Context, serverURL, org, bucket, token string, interval time.Duration, s sensors) {
+	query := url.Values{"bucket": {bucket}, "precision": {"ns"}}
+	if org != "" {
+		query.Set("org", org)
+	}
+	writeURL := strings.TrimSuffix(serverURL, "/") + "/api/v2/write?" + query.Encode()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var body bytes.Buffer
+		for _, c := range s {
+			r, ok := c.latest()
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&body, "mhz19,port=%v co2_ppm=%v,temperature_celsius=%v %d\n",
+				influxTagEscaper.Replace(c.portname), r.CO2, r.Temperature, r.Time.UnixNano())
+		}
+		if body.Len() == 0 {
+			continue
+		}
+		if err := postInflux(writeURL, token, &body); err != nil {
+			slog.Error("writing to InfluxDB failed", "url", serverURL, "bucket", bucket, "error", err)
+		}
+	}
+}
+
+// postInflux posts line protocol to url, authenticating with token if it isn't empty.
+func postInflux(url, token string, body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	resp, err := influxClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
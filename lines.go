@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// withModemLines wraps open to set the serial port's DTR and RTS lines after
+// opening it, each to "on" (asserted), "off" (deasserted) or "" (left as the
+// driver set it, usually on). Some USB serial adapters wire them to the sensor's
+// reset or power, holding it in reset unless they're off.
+func withModemLines(open func(serial.OpenOptions) (io.ReadWriteCloser, error), dtr, rts string) func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+	return func(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+		serialPort, err := open(options)
+		if err != nil {
+			return nil, err
+		}
+		if err := setModemLines(serialPort, dtr, rts); err != nil {
+			serialPort.Close()
+			return nil, fmt.Errorf("setting DTR and RTS: %v", err)
+		}
+		return serialPort, nil
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io"
+
+	"golang.org/x/sys/unix"
+)
+
+// setModemLines sets the DTR and RTS lines of serialPort, as described by
+// withModemLines. It does nothing if serialPort isn't a file, e.g. a mockPort.
+func setModemLines(serialPort io.Reader, dtr, rts string) error {
+	fd, ok := fileDescriptor(serialPort)
+	if !ok {
+		return nil
+	}
+	for _, line := range []struct {
+		state string
+		bit   int
+	}{{dtr, unix.TIOCM_DTR}, {rts, unix.TIOCM_RTS}} {
+		var req uint
+		switch line.state {
+		case "on":
+			req = unix.TIOCMBIS
+		case "off":
+			req = unix.TIOCMBIC
+		default:
+			continue
+		}
+		if err := unix.IoctlSetPointerInt(int(fd), req, line.bit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
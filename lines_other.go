@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// setModemLines is only implemented on Linux.
+func setModemLines(serialPort io.Reader, dtr, rts string) error {
+	return errors.New("only supported on Linux")
+}
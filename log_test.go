@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerFiltersLevels(t *testing.T) {
+	for _, tc := range []struct {
+		level string
+		want  []string // messages logged, of debug, info, warn and error ones
+	}{
+		{level: "debug", want: []string{"debug", "info", "warn", "error"}},
+		{level: "info", want: []string{"info", "warn", "error"}},
+		{level: "warn", want: []string{"warn", "error"}},
+		{level: "error", want: []string{"error"}},
+	} {
+		t.Run(tc.level, func(t *testing.T) {
+			var out bytes.Buffer
+			logger, err := newLogger(&out, "json", tc.level)
+			if err != nil {
+				t.Fatalf("newLogger: %v", err)
+			}
+			logger.Debug("debug")
+			logger.Info("info")
+			logger.Warn("warn")
+			logger.Error("error")
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var record struct{ Msg string }
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					t.Fatalf("log line %q isn't JSON: %v", line, err)
+				}
+				got = append(got, record.Msg)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("logged %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewLoggerText(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := newLogger(&out, "text", "info")
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	logger.Info("read sensor", "co2_ppm", 450)
+	if got := out.String(); !strings.Contains(got, `msg="read sensor" co2_ppm=450`) {
+		t.Errorf("logged %q, want a text record", got)
+	}
+}
+
+func TestNewLoggerInvalid(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("newLogger accepted log format xml")
+	}
+	if _, err := newLogger(&bytes.Buffer{}, "text", "loud"); err == nil {
+		t.Error("newLogger accepted log level loud")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupHandler wraps a slog.Handler to log repeats of the same warning or
+// error at most once per interval, so that e.g. a disconnected sensor doesn't
+// log on every scrape. The next one logged after the interval says how many
+// were suppressed.
+type dedupHandler struct {
+	slog.Handler
+	interval time.Duration
+	attrs    string // from WithAttrs and WithGroup, so differently-scoped loggers aren't mixed up
+
+	mu   *sync.Mutex
+	seen map[string]*dedupEntry // by level, message and attributes
+}
+
+type dedupEntry struct {
+	logged     time.Time // when it was last logged
+	suppressed int       // repeats since then
+}
+
+func newDedupHandler(h slog.Handler, interval time.Duration) *dedupHandler {
+	return &dedupHandler{Handler: h, interval: interval, mu: &sync.Mutex{}, seen: make(map[string]*dedupEntry)}
+}
+
+func (h *dedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.Handler.Handle(ctx, r)
+	}
+	var key strings.Builder
+	fmt.Fprintf(&key, "%s %s %s", r.Level, r.Message, h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&key, " %s", a)
+		return true
+	})
+
+	h.mu.Lock()
+	e := h.seen[key.String()]
+	if e != nil && r.Time.Sub(e.logged) < h.interval {
+		e.suppressed++
+		h.mu.Unlock()
+		return nil
+	}
+	suppressed := 0
+	if e != nil {
+		suppressed = e.suppressed
+	}
+	for k, old := range h.seen {
+		if r.Time.Sub(old.logged) >= h.interval {
+			delete(h.seen, k)
+		}
+	}
+	h.seen[key.String()] = &dedupEntry{logged: r.Time}
+	h.mu.Unlock()
+
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *dedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.Handler = h.Handler.WithAttrs(attrs)
+	for _, a := range attrs {
+		h2.attrs += " " + a.String()
+	}
+	return &h2
+}
+
+func (h *dedupHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.Handler = h.Handler.WithGroup(name)
+	h2.attrs += " " + name + ":"
+	return &h2
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// cachingGatherer serves the metrics it last gathered for up to ttl, so that
+// scrapes in quick succession, e.g. from several Prometheus servers, don't each
+// read the sensors. Unlike --min-read-interval, it caches every one of the
+// sensors' metrics, such as the error counts, not just their readings.
+type cachingGatherer struct {
+	gatherer prometheus.Gatherer
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	families []*dto.MetricFamily
+	err      error
+	gathered time.Time
+}
+
+func (g *cachingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.gathered.IsZero() || time.Since(g.gathered) >= g.ttl {
+		g.families, g.err = g.gatherer.Gather()
+		g.gathered = time.Now()
+	}
+	return g.families, g.err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// mockRampPeriod is how long a mockPort with ramp set takes to go from 400ppm
+// to 2000ppm and back.
+const mockRampPeriod = 10 * time.Minute
+
+// mockPort is an in-memory stand-in for a sensor's serial port, for trying the
+// exporter out, developing dashboards and testing without a sensor. It answers
+// gas concentration, raw CO2, detection range and firmware version requests with
+// valid responses, remembers the detection range it's set to, and ignores other
+// commands.
+type mockPort struct {
+	ramp  bool // ramp the CO2 concentration up and down, rather than holding it steady
+	start time.Time
+
+	mu             sync.Mutex
+	request        []byte       // bytes written so far of the next request
+	pending        bytes.Buffer // response bytes not read yet
+	detectionRange uint16       // ppm
+}
+
+// openMock is a stand-in for serial.Open that returns a mockPort.
+func openMock(ramp bool) func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+	return func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return &mockPort{ramp: ramp, start: time.Now(), detectionRange: 5000}, nil
+	}
+}
+
+func (p *mockPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range b {
+		if len(p.request) == 0 && c != 0xFF {
+			continue // not the start of a frame
+		}
+		p.request = append(p.request, c)
+		if len(p.request) == 9 {
+			p.respond(p.request)
+			p.request = p.request[:0]
+		}
+	}
+	return len(b), nil
+}
+
+// respond queues the response to a request, if it has one.
+func (p *mockPort) respond(request []byte) {
+	cmd := request[2]
+	frame := make([]byte, 9)
+	frame[0] = 0xFF
+	frame[1] = cmd
+	switch cmd {
+	case 0x86:
+		binary.BigEndian.PutUint16(frame[2:4], p.concentration())
+		frame[4] = 25 + 40 // temperature in Celsius + 40
+	case 0x85:
+		binary.BigEndian.PutUint16(frame[4:6], p.concentration())
+	case 0x99:
+		p.detectionRange = binary.BigEndian.Uint16(request[6:8])
+		return
+	case 0x9B:
+		binary.BigEndian.PutUint16(frame[4:6], p.detectionRange)
+	case 0xA0:
+		copy(frame[2:6], "mock")
+	default:
+		return
+	}
+	var sum byte
+	for _, b := range frame[1:8] {
+		sum += b
+	}
+	frame[8] = 0xFF - sum + 1
+	p.pending.Write(frame)
+}
+
+// concentration is the CO2 concentration to report now.
+func (p *mockPort) concentration() uint16 {
+	if !p.ramp {
+		return 450
+	}
+	// Triangle wave between 400 and 2000ppm.
+	phase := float64(time.Since(p.start)%mockRampPeriod) / float64(mockRampPeriod)
+	if phase > 0.5 {
+		phase = 1 - phase
+	}
+	return uint16(400 + 2*phase*1600)
+}
+
+// Read returns queued response bytes, or io.EOF if there are none, as the
+// serial port does once its InterCharacterTimeout elapses.
+func (p *mockPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.pending.Read(b)
+}
+
+func (p *mockPort) Close() error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// connectMQTT connects to an MQTT broker, e.g. tcp://localhost:1883. The client
+// reconnects by itself if the connection drops later.
+func connectMQTT(broker string) (mqtt.Client, error) {
+	// Client IDs must be unique per broker, so include the hostname.
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("mhz19-exporter-" + hostname).
+		SetAutoReconnect(true)
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	token.Wait()
+	return client, token.Error()
+}
+
+// stateTopic is the topic that the sensor on portname publishes its readings to.
+func stateTopic(topic, portname string) string {
+	return topic + "/" + filepath.Base(portname)
+}
+
+// haDiscoveryPrefix is Home Assistant's default MQTT discovery topic prefix.
+const haDiscoveryPrefix = "homeassistant"
+
+// haSensorConfig is a Home Assistant MQTT discovery payload for a sensor.
+// See https://www.home-assistant.io/integrations/sensor.mqtt/.
+type haSensorConfig struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	ValueTemplate     string   `json:"value_template"`
+	UnitOfMeasurement string   `json:"unit_of_measurement"`
+	DeviceClass       string   `json:"device_class"`
+	StateClass        string   `json:"state_class"`
+	Device            haDevice `json:"device"`
+}
+
+type haDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+	Model       string   `json:"model"`
+}
+
+// nonIDChars matches characters that aren't allowed in Home Assistant discovery IDs.
+var nonIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// publishHADiscovery publishes retained Home Assistant discovery configs for the
+// CO2 and temperature of each sensor, pointing at their stateTopic. IDs are
+// derived from the serial port name, so they're stable across restarts.
+func publishHADiscovery(client mqtt.Client, topic string, s sensors) error {
+	for _, c := range s {
+		id := "mhz19" + nonIDChars.ReplaceAllString(c.portname, "_")
+		device := haDevice{
+			Identifiers: []string{id},
+			Name:        "MH-Z19 " + c.portname,
+			Model:       "MH-Z19",
+		}
+		configs := map[string]haSensorConfig{
+			"co2": {
+				Name:              "CO2",
+				ValueTemplate:     "{{ value_json.co2_ppm }}",
+				UnitOfMeasurement: "ppm",
+				DeviceClass:       "carbon_dioxide",
+			},
+			"temperature": {
+				Name:              "Temperature",
+				ValueTemplate:     "{{ value_json.temperature_celsius }}",
+				UnitOfMeasurement: "°C",
+				DeviceClass:       "temperature",
+			},
+		}
+		for object, config := range configs {
+			config.UniqueID = id + "_" + object
+			config.StateTopic = stateTopic(topic, c.portname)
+			config.StateClass = "measurement"
+			config.Device = device
+			payload, err := json.Marshal(config)
+			if err != nil {
+				return err
+			}
+			t := haDiscoveryPrefix + "/sensor/" + id + "/" + object + "/config"
+			if token := client.Publish(t, 0, true, payload); token.Wait() && token.Error() != nil {
+				return fmt.Errorf("publishing to %v: %v", t, token.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// publishMQTT publishes each sensor's latest reading as JSON, retained, to its
+// stateTopic every interval, until ctx is done.
+func publishMQTT(ctx context.Context, client mqtt.Client, topic string, interval time.Duration, s sensors) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, c := range s {
+			r, ok := c.latest()
+			if !ok {
+				continue
+			}
+			payload, err := json.Marshal(r)
+			if err != nil {
+				slog.Error("json.Marshal failed", "reading", r, "error", err)
+				continue
+			}
+			t := stateTopic(topic, c.portname)
+			if token := client.Publish(t, 0, true, payload); token.Wait() && token.Error() != nil {
+				slog.Error("publishing to MQTT failed", "topic", t, "error", token.Error())
+			}
+		}
+	}
+}
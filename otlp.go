@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The subset of the OTLP metrics protocol's JSON encoding needed to export gauges.
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding. 64-bit
+// integers are encoded as strings.
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	otlpMetric struct {
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+		Unit        string    `json:"unit"`
+		Gauge       otlpGauge `json:"gauge"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpDataPoint struct {
+		Attributes   []otlpAttribute `json:"attributes"`
+		TimeUnixNano string          `json:"timeUnixNano"`
+		AsDouble     float64         `json:"asDouble"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+)
+
+// otlpClient sends metrics to the OTLP endpoint.
+var otlpClient = &http.Client{Timeout: 10 * time.Second}
+
+// exportOTLP exports each sensor's latest reading as OTLP gauges to the OTLP/HTTP
+// endpoint, e.g. http://localhost:4318, every interval, until ctx is done.
+func exportOTLP(ctx context.Context, endpoint string, interval time.Duration, s sensors) {
+	url := strings.TrimSuffix(endpoint, "/") + "/v1/metrics"
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		body, err := json.Marshal(otlpMetrics(s))
+		if err != nil {
+			slog.Error("json.Marshal failed", "error", err)
+			continue
+		}
+		if err := postOTLP(url, body); err != nil {
+			slog.Error("exporting to OTLP endpoint failed", "url", url, "error", err)
+		}
+	}
+}
+
+// otlpMetrics builds a request exporting each sensor's latest reading, labelled
+// with its serial port.
+func otlpMetrics(s sensors) otlpRequest {
+	co2 := otlpMetric{
+		Name:        "mhz19.co2.concentration",
+		Description: "Carbon Dioxide Concentration",
+		Unit:        "ppm",
+	}
+	temperature := otlpMetric{
+		Name:        "mhz19.temperature",
+		Description: "Sensor Temperature",
+		Unit:        "Cel",
+	}
+	for _, c := range s {
+		r, ok := c.latest()
+		if !ok {
+			continue
+		}
+		attributes := []otlpAttribute{{Key: "port", Value: otlpValue{StringValue: c.portname}}}
+		timestamp := strconv.FormatInt(r.Time.UnixNano(), 10)
+		co2.Gauge.DataPoints = append(co2.Gauge.DataPoints, otlpDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: timestamp,
+			AsDouble:     r.CO2,
+		})
+		temperature.Gauge.DataPoints = append(temperature.Gauge.DataPoints, otlpDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: timestamp,
+			AsDouble:     r.Temperature,
+		})
+	}
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: "mhz19-exporter"}},
+		}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "github.com/mhansen/mhz19-exporter", Version: version},
+			Metrics: []otlpMetric{co2, temperature},
+		}},
+	}}}
+}
+
+// postOTLP posts an encoded export request to url.
+func postOTLP(url string, body []byte) error {
+	resp, err := otlpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mhansen/mhz19"
+)
+
+// The parts of the sensor's serial protocol that github.com/mhansen/mhz19 doesn't provide.
+// Datasheet: https://www.winsen-sensor.com/d/files/PDF/Infrared%20Gas%20Sensor/NDIR%20CO2%20SENSOR/MH-Z19%20CO2%20Ver1.0.pdf
+
+// request is a command frame that can be sent to the sensor, e.g. *command.
+type request interface {
+	Write(w io.Writer) error
+}
+
+// command is a 9-byte frame laid out like mhz19.GasConcentrationRequest.
+type command struct {
+	Start    byte
+	SensorNo byte
+	Command  byte
+	Byte3    byte
+	Byte4    byte
+	Byte5    byte
+	Byte6    byte
+	Byte7    byte
+	Checksum byte
+}
+
+// sensorNumber is byte 1 of every command frame. Sensors answer 0x01, the
+// default, but some clones and daisy-chained setups need another.
+var sensorNumber byte = 0x01
+
+// newCommand builds the frame for command cmd to sensorNumber, with data as
+// bytes 3-7, and computes its checksum.
+func newCommand(cmd byte, data [5]byte) *command {
+	c := &command{
+		Start:    0xFF,
+		SensorNo: sensorNumber,
+		Command:  cmd,
+		Byte3:    data[0],
+		Byte4:    data[1],
+		Byte5:    data[2],
+		Byte6:    data[3],
+		Byte7:    data[4],
+	}
+	c.Checksum = c.checksum()
+	return c
+}
+
+// newGasConcentrationRequest asks the sensor for its CO2 concentration and
+// temperature, which it sends in a response read by readGasConcentrationResponse.
+// It's the same frame as mhz19.NewGasConcentrationRequest, unless sensorNumber
+// isn't the default.
+func newGasConcentrationRequest() *command {
+	return newCommand(0x86, [5]byte{})
+}
+
+// newZeroCalibrationCommand calibrates the sensor's zero point to 400ppm. The sensor
+// should have been in fresh air for 20 minutes first. It sends no response.
+func newZeroCalibrationCommand() *command {
+	return newCommand(0x87, [5]byte{})
+}
+
+// Span calibration accepts concentrations in this range, in ppm.
+const (
+	minSpanPPM = 1000
+	maxSpanPPM = 5000
+)
+
+// newSpanCalibrationCommand calibrates the sensor's span point to ppm. The sensor
+// should have been zero point calibrated, then held at ppm for 20 minutes first.
+// It sends no response.
+func newSpanCalibrationCommand(ppm uint16) *command {
+	return newCommand(0x88, [5]byte{byte(ppm >> 8), byte(ppm)})
+}
+
+// newABCCommand turns the sensor's Automatic Baseline Correction on or off. ABC
+// periodically recalibrates the zero point to the lowest reading seen, which
+// drifts readings in rooms that never get down to fresh air. It sends no response.
+func newABCCommand(enabled bool) *command {
+	var data [5]byte
+	if enabled {
+		data[0] = 0xA0
+	}
+	return newCommand(0x79, data)
+}
+
+// Limits of the ABC period newABCPeriodCommand can set, since it's sent as a
+// byte in units of 9 minutes.
+const (
+	minABCPeriodHours = 1
+	maxABCPeriodHours = 38
+)
+
+// newABCPeriodCommand turns the sensor's Automatic Baseline Correction on, running
+// every hours rather than the default 24. The period isn't in the datasheet, and
+// not all firmware supports it, though all accept 24, which is the same as
+// newABCCommand(true). It sends no response.
+func newABCPeriodCommand(hours int) *command {
+	return newCommand(0x79, [5]byte{byte(hours * 20 / 3)}) // 24 hours is 0xA0
+}
+
+// newDetectionRangeCommand sets the sensor's detection range to 0-ppm. The MH-Z19B
+// and MH-Z19C support 2000 and 5000; the smaller range is more accurate. It sends no response.
+func newDetectionRangeCommand(ppm uint16) *command {
+	return newCommand(0x99, [5]byte{3: byte(ppm >> 8), 4: byte(ppm)})
+}
+
+// newDetectionRangeRequest asks the sensor for its detection range, which it sends
+// in a response read by readDetectionRangeResponse. Only the MH-Z19C documents it.
+func newDetectionRangeRequest() *command {
+	return newCommand(0x9B, [5]byte{})
+}
+
+// readDetectionRangeResponse reads the response to newDetectionRangeRequest,
+// returning the detection range in ppm.
+func readDetectionRangeResponse(r io.Reader) (uint16, error) {
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+	if buf[0] != 0xFF || buf[1] != 0x9B {
+		return 0, fmt.Errorf("not a detection range response: % x", buf)
+	}
+	if err := verifyChecksum(buf); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(buf[4:6]), nil
+}
+
+// newFirmwareVersionRequest asks the sensor for its firmware version, which it sends
+// in a response read by readFirmwareVersionResponse. Not all sensors support it.
+func newFirmwareVersionRequest() *command {
+	return newCommand(0xA0, [5]byte{})
+}
+
+// readFirmwareVersionResponse reads the response to newFirmwareVersionRequest,
+// returning the version, e.g. "0443".
+func readFirmwareVersionResponse(r io.Reader) (string, error) {
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	if buf[0] != 0xFF || buf[1] != 0xA0 {
+		return "", fmt.Errorf("not a firmware version response: % x", buf)
+	}
+	if err := verifyChecksum(buf); err != nil {
+		return "", err
+	}
+	return string(buf[2:6]), nil
+}
+
+// newRawCO2Request asks the sensor for its raw CO2 concentration, which it sends
+// in a response read by readRawCO2Response. The command is undocumented, and not
+// all firmware supports it.
+func newRawCO2Request() *command {
+	return newCommand(0x85, [5]byte{})
+}
+
+// readRawCO2Response reads the response to newRawCO2Request, returning the CO2
+// concentration in ppm before the sensor clamps and smooths it.
+func readRawCO2Response(r io.Reader) (uint16, error) {
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+	if buf[0] != 0xFF || buf[1] != 0x85 {
+		return 0, fmt.Errorf("not a raw CO2 response: % x", buf)
+	}
+	if err := verifyChecksum(buf); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(buf[4:6]), nil
+}
+
+// checksum computes the checksum of the frame.
+func (c *command) checksum() byte {
+	return checksum([]byte{c.Start, c.SensorNo, c.Command, c.Byte3, c.Byte4, c.Byte5, c.Byte6, c.Byte7, c.Checksum})
+}
+
+// checksum computes the checksum of bytes 1-7 of a 9-byte frame, as described in the datasheet.
+func checksum(frame []byte) byte {
+	var sum byte
+	for _, b := range frame[1:8] {
+		sum += b
+	}
+	return 0xFF - sum + 1
+}
+
+// checksumError is returned for a frame whose checksum byte is wrong.
+type checksumError struct {
+	got, want byte
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("checksum failed: got %#02x want %#02x", e.got, e.want)
+}
+
+// verifyChecksum checks the last byte of a 9-byte frame is its checksum,
+// returning a *checksumError if not.
+func verifyChecksum(frame []byte) error {
+	if want := checksum(frame); frame[8] != want {
+		return &checksumError{got: frame[8], want: want}
+	}
+	return nil
+}
+
+func (c *command) Write(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, c)
+}
+
+// gasConcentrationResponse is an mhz19.GasConcentrationResponse along with the
+// status byte that the library doesn't decode.
+type gasConcentrationResponse struct {
+	mhz19.GasConcentrationResponse
+	status byte
+}
+
+// Status returns byte 5 of the response, which some sensors use to report their
+// state. The datasheet doesn't document it, and what it means varies between
+// models and firmware:
+//
+//   - 0x00: reported by most MH-Z19B and MH-Z19C sensors all the time.
+//   - 0x40: reported by some MH-Z19 sensors once they're warmed up and working normally.
+//   - anything else: reported by some sensors while preheating or after a fault.
+//
+// So it's best compared against what the same sensor reports when known to be healthy.
+func (r gasConcentrationResponse) Status() byte {
+	return r.status
+}
+
+func (r gasConcentrationResponse) String() string {
+	return fmt.Sprintf("co2=%dppm temperature=%d°C status=%#02x", r.Concentration, r.Temperature(), r.Status())
+}
+
+// maxLeadingNoise is how many bytes of noise readGasConcentrationFrame skips
+// looking for the start of a frame.
+const maxLeadingNoise = 16
+
+// Errors for a gas concentration response that doesn't start within maxLeadingNoise
+// bytes, or for a frame that doesn't start like one.
+var (
+	// errBadStartByte means there was no 0xFF start byte.
+	errBadStartByte = errors.New("no gas concentration response start byte found")
+	// errBadCommandEcho means no start byte was followed by the 0x86 command.
+	errBadCommandEcho = errors.New("no gas concentration response command echo found")
+)
+
+// shortReadError is returned when a response stops before all 9 bytes of its
+// frame arrive, usually because the serial port timed out.
+type shortReadError struct {
+	n int // bytes read
+}
+
+func (e *shortReadError) Error() string {
+	return fmt.Sprintf("short read: got %d of 9 bytes", e.n)
+}
+
+func (e *shortReadError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// readGasConcentrationResponse is like mhz19.ReadGasConcentrationResponse, but
+// first skips any bytes before the 0xFF 0x86 that starts the response. Cheap
+// USB-UART adapters often add a byte or two of noise.
+func readGasConcentrationResponse(r io.Reader) (*gasConcentrationResponse, error) {
+	frame, err := readGasConcentrationFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseGasConcentrationResponse(frame)
+}
+
+// readGasConcentrationFrame reads the 9-byte frame of a gas concentration
+// response, skipping up to maxLeadingNoise bytes before the 0xFF 0x86 that starts it.
+// It doesn't check the checksum.
+func readGasConcentrationFrame(r io.Reader) ([]byte, error) {
+	frame := make([]byte, 9)
+	read := 0
+	sawStart := false
+	for frame[0] != 0xFF || frame[1] != 0x86 {
+		if read == 2+maxLeadingNoise {
+			if sawStart {
+				return nil, errBadCommandEcho
+			}
+			return nil, errBadStartByte
+		}
+		frame[0] = frame[1]
+		if _, err := io.ReadFull(r, frame[1:2]); err != nil {
+			if err == io.EOF && read > 0 {
+				err = &shortReadError{read}
+			}
+			return nil, err
+		}
+		read++
+		sawStart = sawStart || frame[1] == 0xFF
+	}
+	if n, err := io.ReadFull(r, frame[2:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = &shortReadError{2 + n}
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+// parseGasConcentrationResponse decodes the frame of a gas concentration response.
+func parseGasConcentrationResponse(frame []byte) (*gasConcentrationResponse, error) {
+	if frame[0] != 0xFF {
+		return nil, errBadStartByte
+	}
+	if frame[1] != 0x86 {
+		return nil, errBadCommandEcho
+	}
+	if err := verifyChecksum(frame); err != nil {
+		return nil, err
+	}
+	resp := &gasConcentrationResponse{status: frame[5]}
+	binary.Read(bytes.NewReader(frame), binary.BigEndian, &resp.GasConcentrationResponse)
+	return resp, nil
+}
+
+// readGasConcentrationResponseContext is like readGasConcentrationResponse,
+// but gives up with ctx's error once ctx is done. It notices between reads from r,
+// so relies on them returning periodically, as the serial port does after its
+// InterCharacterTimeout.
+func readGasConcentrationResponseContext(ctx context.Context, r io.Reader) (*gasConcentrationResponse, error) {
+	return readGasConcentrationResponse(contextReader{ctx, r})
+}
+
+// contextReader fails reads once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// goodFrame is a gas concentration response of 450ppm at 25°C.
+const goodFrame = "ff 86 01 c2 41 00 00 00 76"
+
+// hexBytes decodes space-separated hex, e.g. "ff 86".
+func hexBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestReadGasConcentrationFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  error // nil if the good frame should be found
+	}{
+		{name: "good frame", input: goodFrame},
+		{name: "leading noise", input: "00 3f " + goodFrame},
+		{name: "leading 0xFF", input: "ff ff " + goodFrame},
+		{name: "good frame after the most junk skipped", input: strings.Repeat("aa ", maxLeadingNoise) + goodFrame},
+		{name: "too much junk", input: strings.Repeat("aa ", maxLeadingNoise+2) + goodFrame, want: errBadStartByte},
+		{name: "no 0xFF", input: strings.Repeat("00 ", 2+maxLeadingNoise+9), want: errBadStartByte},
+		{name: "0xFF without 0x86", input: strings.Repeat("ff 01 ", 1+maxLeadingNoise/2) + goodFrame, want: errBadCommandEcho},
+		{name: "0xFF as the last byte read", input: strings.Repeat("00 ", 1+maxLeadingNoise) + "ff " + goodFrame, want: errBadCommandEcho},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			frame, err := readGasConcentrationFrame(bytes.NewReader(hexBytes(t, tc.input)))
+			if err != tc.want {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+			if tc.want == nil && !bytes.Equal(frame, hexBytes(t, goodFrame)) {
+				t.Errorf("frame = % x, want %v", frame, goodFrame)
+			}
+		})
+	}
+}
+
+func TestReadGasConcentrationFrameShortRead(t *testing.T) {
+	for _, input := range []string{
+		"ff 86 01 c2", // the start of a frame
+		"00 3f 00 ff", // noise
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := readGasConcentrationFrame(bytes.NewReader(hexBytes(t, input)))
+			var short *shortReadError
+			if !errors.As(err, &short) || short.n != 4 {
+				t.Fatalf("err = %#v, want &shortReadError{n: 4}", err)
+			}
+			if reason := readErrorReason(err); reason != "short_read" {
+				t.Errorf("readErrorReason = %q, want short_read", reason)
+			}
+		})
+	}
+}
+
+func TestGasConcentrationResponseString(t *testing.T) {
+	resp, err := parseGasConcentrationResponse(hexBytes(t, "ff 86 01 c2 41 40 00 00 36"))
+	if err != nil {
+		t.Fatalf("parseGasConcentrationResponse: %v", err)
+	}
+	if got, want := resp.String(), "co2=450ppm temperature=25°C status=0x40"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
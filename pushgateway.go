@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushJob is the job label of metrics pushed to a Pushgateway.
+const pushJob = "mhz19"
+
+// pushMetrics pushes each sensor's metrics to the Pushgateway at url every
+// interval, until ctx is done. Each sensor is pushed to its own group, with an
+// instance label of its serial port's base name, so sensors don't replace each
+// other's metrics.
+func pushMetrics(ctx context.Context, url string, interval time.Duration, s sensors) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, c := range s {
+			instance := filepath.Base(c.portname)
+			if err := push.New(url, pushJob).Grouping("instance", instance).Collector(c).Push(); err != nil {
+				slog.Error("pushing to Pushgateway failed", "url", url, "instance", instance, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// reading is a valid reading from a sensor.
+type reading struct {
+	CO2         float64   `json:"co2_ppm"`             // corrected by --co2-scale and --co2-offset
+	Temperature float64   `json:"temperature_celsius"` // corrected by --temp-offset
+	Time        time.Time `json:"timestamp"`
+}
+
+// latest returns the sensor's most recent valid reading, reading it now first if
+// it isn't being polled in the background. ok is false if there hasn't been one.
+func (c *mhz19Collector) latest() (r reading, ok bool) {
+	if c.pollInterval == 0 {
+		c.readShared()
+	}
+	return c.cached()
+}
+
+// cached returns the sensor's most recent valid reading without reading it.
+// ok is false if there hasn't been one, or if it isn't exportable, like the 0
+// ppm the sensor reports while preheating.
+func (c *mhz19Collector) cached() (r reading, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resp == nil || !c.exportable(c.resp) {
+		return reading{}, false
+	}
+	return c.readingOf(c.resp, c.readTime), true
+}
+
+// readingOf returns a response read at t as a reading, corrected as the metrics
+// are, so that every output of it agrees with them.
+func (c *mhz19Collector) readingOf(resp *gasConcentrationResponse, t time.Time) reading {
+	return reading{
+		CO2:         correctCO2(float64(resp.Concentration), c.co2Scale, c.co2Offset),
+		Temperature: offsetTemperature(resp.Temperature(), c.tempOffset),
+		Time:        t,
+	}
+}
+
+// freshReading returns the sensor's latest reading, or an error unless it's recent.
+func (c *mhz19Collector) freshReading() (reading, error) {
+	start := time.Now()
+	r, ok := c.latest()
+	if !ok {
+		return reading{}, fmt.Errorf("no valid reading from sensor yet")
+	}
+	var fresh bool
+	if c.pollInterval == 0 {
+		fresh = !r.Time.Before(start) || time.Since(r.Time) < c.minReadInterval
+	} else {
+		// Allow a poll interval of slack, since the next poll may be in progress.
+		fresh = time.Since(r.Time) <= 2*c.pollInterval
+	}
+	if !fresh {
+		return r, fmt.Errorf("last valid reading from sensor was %v ago", time.Since(r.Time).Round(time.Second))
+	}
+	return r, nil
+}
+
+// readingJSON handles GET /reading.json, responding with the sensor's latest
+// reading, or 503 unless it's recent.
+func (c *mhz19Collector) readingJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	reading, err := c.freshReading()
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(reading)
+}
+
+// readOnce reads each sensor and prints its reading to stdout, for --once. It
+// reports whether every sensor was read.
+func readOnce(s sensors) bool {
+	out, err := newLogger(os.Stdout, *logFormat, "info")
+	if err != nil {
+		fatal("invalid --log-format", "error", err)
+	}
+	ok := true
+	for _, c := range s {
+		c.read()
+		r, read := c.cached()
+		if !read {
+			slog.Error("reading sensor failed", "portname", c.portname)
+			ok = false
+			continue
+		}
+		out.Info("reading", "portname", c.portname, "co2_ppm", r.CO2, "temperature_celsius", r.Temperature)
+	}
+	return ok
+}
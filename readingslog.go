@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// readingsLog appends each sensor's readings to a file as JSON lines, for
+// --log-readings-file. It's shared between sensors.
+type readingsLog struct {
+	path    string
+	maxSize int64 // bytes after which the file is rotated to path.1, or 0 to let it grow
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// loggedReading is a line of a readingsLog.
+type loggedReading struct {
+	Time        time.Time `json:"ts"`
+	Port        string    `json:"port"`
+	CO2         float64   `json:"co2"`
+	Temperature float64   `json:"temp"`
+}
+
+// openReadingsLog opens path to append readings to, creating it if need be.
+func openReadingsLog(path string, maxSize int64) (*readingsLog, error) {
+	l := &readingsLog{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *readingsLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// write appends r to the file, rotating it first if it's reached maxSize. Each
+// line goes straight to the file, so none are lost if the exporter is killed.
+func (l *readingsLog) write(r loggedReading) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotating %v: %v", l.path, err)
+		}
+	}
+	if l.f == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate renames the file to path.1, replacing any older one, and starts a new
+// file. Must be called with mu held.
+func (l *readingsLog) rotate() error {
+	l.f.Close()
+	l.f = nil
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// close closes the file.
+func (l *readingsLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// A capture file records a serial port's traffic, one read or write per line:
+//
+//	2026-10-14T06:00:00.123456789Z > ff 01 86 00 00 00 00 00 79
+//	2026-10-14T06:00:00.131272812Z < ff 86 02 78 41 00 00 00 bf
+//	2026-10-14T06:00:05.124631043Z < EOF
+//
+// Each line is a timestamp, then > for bytes written to the sensor or < for bytes
+// read from it, in hex, or the error the read returned instead. The timestamp
+// and direction are optional, so a plain hex dump of the bytes the sensor sent
+// will do too. Blank lines and lines starting with # are ignored.
+
+// replayedRead is a read recorded in a capture file.
+type replayedRead struct {
+	data []byte
+	err  error
+}
+
+// replayedExchange is a write recorded in a capture file, and the reads recorded
+// after it, up to the next write.
+type replayedExchange struct {
+	write []byte // nil for reads recorded before any write, e.g. in a plain hex dump
+	reads []replayedRead
+}
+
+// readCapture reads the writes and reads recorded in a capture file, grouping
+// each write with the reads after it.
+func readCapture(r io.Reader) ([]replayedExchange, error) {
+	exchanges := []replayedExchange{{}}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+			fields = fields[1:]
+		}
+		write := len(fields) > 0 && fields[0] == ">"
+		if len(fields) > 0 && (write || fields[0] == "<") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: no bytes", line)
+		}
+		data, err := hex.DecodeString(strings.Join(fields, ""))
+		if write {
+			if err != nil {
+				return nil, fmt.Errorf("line %d: written bytes aren't hex: %v", line, err)
+			}
+			exchanges = append(exchanges, replayedExchange{write: data})
+			continue
+		}
+		last := &exchanges[len(exchanges)-1]
+		if err != nil {
+			// Not bytes, so the error the read returned.
+			text := strings.Join(fields, " ")
+			switch text {
+			case io.EOF.Error():
+				last.reads = append(last.reads, replayedRead{err: io.EOF})
+			default:
+				last.reads = append(last.reads, replayedRead{err: errors.New(text)})
+			}
+			continue
+		}
+		last.reads = append(last.reads, replayedRead{data: data})
+	}
+	return exchanges, scanner.Err()
+}
+
+// replayPort is a stand-in for a serial port that replays what a sensor sent in
+// a capture file, to reproduce a problem. Each write of a recorded request
+// releases the reads recorded after it, skipping any recorded requests that
+// weren't written, e.g. the startup requests when replaying with --once; a
+// write that wasn't recorded gets no response. Reads recorded before any write
+// are released straight away. Once the released reads run out, it returns
+// io.EOF, as the serial port does once its InterCharacterTimeout elapses.
+type replayPort struct {
+	mu        sync.Mutex
+	exchanges []replayedExchange // whose writes haven't been matched yet
+	reads     []replayedRead     // released, but not read yet
+	pending   []byte             // rest of the current read
+}
+
+// openReplay reads a capture file, and returns a stand-in for serial.Open that
+// returns a replayPort for it.
+func openReplay(path string) (func(serial.OpenOptions) (io.ReadWriteCloser, error), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	exchanges, err := readCapture(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return newReplayPort(exchanges), nil
+	}, nil
+}
+
+// newReplayPort returns a replayPort for the exchanges read from a capture file.
+func newReplayPort(exchanges []replayedExchange) *replayPort {
+	return &replayPort{reads: exchanges[0].reads, exchanges: exchanges[1:]}
+}
+
+func (p *replayPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) == 0 {
+		if len(p.reads) == 0 {
+			return 0, io.EOF
+		}
+		r := p.reads[0]
+		p.reads = p.reads[1:]
+		if r.err != nil {
+			return 0, r.err
+		}
+		p.pending = r.data
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// Write releases the reads recorded after the next recorded write of b, and
+// discards any released before, as send flushes a real serial port's input.
+func (p *replayPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reads, p.pending = nil, nil
+	for i, e := range p.exchanges {
+		if bytes.Equal(e.write, b) {
+			p.reads = e.reads
+			p.exchanges = p.exchanges[i+1:]
+			break
+		}
+	}
+	return len(b), nil
+}
+
+func (p *replayPort) Close() error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// startupCapture is a capture of a server starting up: the detection range and
+// firmware version requests, then a gas concentration request.
+const startupCapture = `
+2026-10-14T06:00:00.000000000Z > ff 01 9b 00 00 00 00 00 64
+2026-10-14T06:00:00.010000000Z < ff 9b 00 00 13 88 00 00 ca
+2026-10-14T06:00:00.020000000Z > ff 01 a0 00 00 00 00 00 5f
+2026-10-14T06:00:00.030000000Z < ff a0 6d 6f 63 6b 00 00 b6
+2026-10-14T06:00:00.040000000Z > ff 01 86 00 00 00 00 00 79
+2026-10-14T06:00:00.050000000Z < ff 86 01 c2
+2026-10-14T06:00:00.060000000Z < 41 00 00 00 76
+`
+
+func newTestReplayPort(t *testing.T, capture string) *replayPort {
+	t.Helper()
+	exchanges, err := readCapture(strings.NewReader(capture))
+	if err != nil {
+		t.Fatalf("readCapture: %v", err)
+	}
+	return newReplayPort(exchanges)
+}
+
+func TestReplayPortSkipsRequestsNotWritten(t *testing.T) {
+	p := newTestReplayPort(t, startupCapture)
+	// As with --once, which doesn't send the startup requests.
+	if err := newGasConcentrationRequest().Write(p); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := readGasConcentrationResponse(p)
+	if err != nil {
+		t.Fatalf("readGasConcentrationResponse: %v", err)
+	}
+	if resp.Concentration != 450 || resp.Temperature() != 25 {
+		t.Errorf("got %v, want co2=450ppm temperature=25°C", resp)
+	}
+	if _, err := p.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after the response: err = %v, want io.EOF", err)
+	}
+}
+
+func TestReplayPortAnswersInOrder(t *testing.T) {
+	p := newTestReplayPort(t, startupCapture)
+	if err := newDetectionRangeRequest().Write(p); err != nil {
+		t.Fatal(err)
+	}
+	if ppm, err := readDetectionRangeResponse(p); err != nil || ppm != 5000 {
+		t.Errorf("readDetectionRangeResponse = %v, %v, want 5000", ppm, err)
+	}
+	if err := newFirmwareVersionRequest().Write(p); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := readFirmwareVersionResponse(p); err != nil || version != "mock" {
+		t.Errorf("readFirmwareVersionResponse = %q, %v, want mock", version, err)
+	}
+	// Already replayed, so there's no response left to it.
+	if err := newDetectionRangeRequest().Write(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readDetectionRangeResponse(p); err != io.EOF {
+		t.Errorf("second readDetectionRangeResponse: err = %v, want io.EOF", err)
+	}
+}
+
+func TestReplayPortHexDump(t *testing.T) {
+	// Without writes, the reads are replayed in order whatever's written.
+	p := newTestReplayPort(t, "ff 86 01 c2 41 00 00 00 76\n")
+	resp, err := readGasConcentrationResponse(p)
+	if err != nil {
+		t.Fatalf("readGasConcentrationResponse: %v", err)
+	}
+	if resp.Concentration != 450 {
+		t.Errorf("co2 = %d, want 450", resp.Concentration)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+)
+
+// systemdReady is what the exporter waits for before sending READY=1 to systemd.
+var systemdReady struct {
+	sync.Mutex
+	listening bool // the HTTP listener is bound
+	read      bool // a sensor has given a valid reading
+	sent      bool
+}
+
+// notifySystemd tells systemd, if it's running the exporter as a Type=notify
+// service, that a sensor gave a valid reading, petting its watchdog (see
+// WatchdogSec). Readings happen every --poll-interval, which should be well
+// within WatchdogSec.
+func notifySystemd() {
+	systemdReady.Lock()
+	systemdReady.read = true
+	notifyReady()
+	systemdReady.Unlock()
+	sdNotify("WATCHDOG=1")
+}
+
+// notifyListening tells systemd that the HTTP listener is bound, so that it's
+// told the exporter is ready once it has a reading to serve too.
+func notifyListening() {
+	systemdReady.Lock()
+	systemdReady.listening = true
+	notifyReady()
+	systemdReady.Unlock()
+}
+
+// notifyReady sends READY=1 once the exporter is listening and has read a
+// sensor. Must be called with systemdReady locked.
+func notifyReady() {
+	if systemdReady.listening && systemdReady.read && !systemdReady.sent {
+		sdNotify("READY=1")
+		systemdReady.sent = true
+	}
+}
+
+// sdNotify sends state to $NOTIFY_SOCKET, if it's set. See sd_notify(3).
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // abstract namespace socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("connecting to systemd notification socket failed", "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("notifying systemd failed", "state", state, "error", err)
+	}
+}
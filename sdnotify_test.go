@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listenNotify points $NOTIFY_SOCKET at a new socket for the test and returns
+// it, resetting what's been sent to systemd so far.
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+	systemdReady.Lock()
+	systemdReady.listening, systemdReady.read, systemdReady.sent = false, false, false
+	systemdReady.Unlock()
+	return conn
+}
+
+// notified returns the states sent to conn so far.
+func notified(t *testing.T, conn *net.UnixConn) []string {
+	t.Helper()
+	var states []string
+	b := make([]byte, 64)
+	for {
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, err := conn.Read(b)
+		if err != nil {
+			return states
+		}
+		states = append(states, string(b[:n]))
+	}
+}
+
+func TestReadyOnceListeningAndRead(t *testing.T) {
+	conn := listenNotify(t)
+	notifySystemd()
+	if got := notified(t, conn); len(got) != 1 || got[0] != "WATCHDOG=1" {
+		t.Errorf("after a reading, before listening, sent %q, want only WATCHDOG=1", got)
+	}
+	notifyListening()
+	if got := notified(t, conn); len(got) != 1 || got[0] != "READY=1" {
+		t.Errorf("once listening, sent %q, want READY=1", got)
+	}
+	notifySystemd()
+	if got := notified(t, conn); len(got) != 1 || got[0] != "WATCHDOG=1" {
+		t.Errorf("after another reading, sent %q, want only WATCHDOG=1", got)
+	}
+}
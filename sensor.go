@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// Sensor is what mhz19Collector reads and commands the sensor through, so that
+// tests and other backends can stand in for a sensor on a serial port.
+type Sensor interface {
+	// ReadConcentration requests a reading and waits for it until ctx is done.
+	ReadConcentration(ctx context.Context) (*gasConcentrationResponse, error)
+	// ReadRawCO2 requests the raw CO2 concentration in ppm, which not all
+	// firmware supports, and waits for it until ctx is done.
+	ReadRawCO2(ctx context.Context) (uint16, error)
+	// FirmwareVersion requests the firmware version, e.g. "0443".
+	FirmwareVersion() (string, error)
+	// DetectionRange requests the detection range in ppm.
+	DetectionRange() (uint16, error)
+	// Command sends a command that has no response.
+	Command(cmd request) error
+	// Close releases the sensor. Later calls may reopen it.
+	Close() error
+}
+
+// serialSensor is a Sensor on a serial port, which it reopens if it was
+// disconnected after an error. It's safe for concurrent use: requests take
+// turns on the port, and stats doesn't wait for them.
+type serialSensor struct {
+	ioMu    sync.Mutex // held for all I/O on port, including reopening it
+	options serial.OpenOptions
+	open    func(serial.OpenOptions) (io.ReadWriteCloser, error) // serial.Open, or a stand-in like openMock
+	port    io.ReadWriteCloser                                   // nil while disconnected, guarded by ioMu
+
+	// Reconnection state, guarded by ioMu.
+	openBackoff   time.Duration
+	nextOpen      time.Time
+	disconnectErr error // why the serial port was last closed
+
+	mu    sync.Mutex // guards stats, and is only held briefly
+	stats serialStats
+}
+
+// serialStats are a serialSensor's counts of its serial port's activity.
+type serialStats struct {
+	connected    bool
+	reconnects   uint64 // times the serial port was reopened
+	bytesRead    uint64
+	bytesWritten uint64
+	writeErrors  uint64
+
+	// Latest complete response frame, valid or not, for /debug/frame.
+	lastFrame     []byte
+	lastFrameTime time.Time
+}
+
+// newSerialSensor returns a serialSensor on serialPort, which was opened with
+// open and options, so it can be reopened the same way.
+func newSerialSensor(options serial.OpenOptions, open func(serial.OpenOptions) (io.ReadWriteCloser, error), serialPort io.ReadWriteCloser) *serialSensor {
+	s := &serialSensor{options: options, open: open}
+	s.port = s.countBytes(serialPort)
+	s.stats.connected = true
+	return s
+}
+
+func (s *serialSensor) ReadConcentration(ctx context.Context) (*gasConcentrationResponse, error) {
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+	if err := s.send(newGasConcentrationRequest()); err != nil {
+		return nil, &sendError{err}
+	}
+	frame, err := readGasConcentrationFrame(contextReader{ctx, s.port})
+	if err != nil {
+		if readErrorReason(err) == "io" {
+			s.disconnect(err)
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	s.stats.lastFrame, s.stats.lastFrameTime = frame, time.Now()
+	s.mu.Unlock()
+	return parseGasConcentrationResponse(frame)
+}
+
+func (s *serialSensor) ReadRawCO2(ctx context.Context) (uint16, error) {
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+	if err := s.send(newRawCO2Request()); err != nil {
+		return 0, &sendError{err}
+	}
+	return readRawCO2Response(contextReader{ctx, s.port})
+}
+
+func (s *serialSensor) FirmwareVersion() (string, error) {
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+	if err := s.send(newFirmwareVersionRequest()); err != nil {
+		return "", &sendError{err}
+	}
+	return readFirmwareVersionResponse(s.port)
+}
+
+func (s *serialSensor) DetectionRange() (uint16, error) {
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+	if err := s.send(newDetectionRangeRequest()); err != nil {
+		return 0, &sendError{err}
+	}
+	return readDetectionRangeResponse(s.port)
+}
+
+func (s *serialSensor) Command(cmd request) error {
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+	return s.send(cmd)
+}
+
+// Close closes the serial port, if it's open.
+func (s *serialSensor) Close() error {
+	s.ioMu.Lock()
+	defer s.ioMu.Unlock()
+	if s.port == nil {
+		return nil
+	}
+	err := s.port.Close()
+	s.port = nil
+	s.setConnected(false)
+	return err
+}
+
+// serialStats returns the counts of the serial port's activity so far.
+func (s *serialSensor) serialStats() serialStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *serialSensor) setConnected(connected bool) {
+	s.mu.Lock()
+	s.stats.connected = connected
+	s.mu.Unlock()
+}
+
+// send writes a request to the sensor, reopening the serial port first if it's closed.
+// Must be called with ioMu held.
+func (s *serialSensor) send(req request) error {
+	if s.port == nil && !s.reconnect() {
+		return errDisconnected
+	}
+	// Discard the rest of any response that wasn't read in full, e.g. after a
+	// timeout or checksum error, which would misalign the response to req.
+	if err := flushInput(s.port); err != nil {
+		slog.Warn("flushing serial port failed", "portname", s.options.PortName, "error", err)
+	}
+	if err := req.Write(s.port); err != nil {
+		slog.Error("couldn't write to serial port", "portname", s.options.PortName, "error", err)
+		s.mu.Lock()
+		s.stats.writeErrors++
+		s.mu.Unlock()
+		s.disconnect(err)
+		return err
+	}
+	return nil
+}
+
+// disconnect closes the serial port after an I/O error, so that the next request reopens it.
+// Must be called with ioMu held.
+func (s *serialSensor) disconnect(err error) {
+	slog.Warn("closing serial port after error", "portname", s.options.PortName, "error", err)
+	s.port.Close()
+	s.port = nil
+	s.disconnectErr = err
+	s.setConnected(false)
+}
+
+// reconnect tries to reopen a disconnected serial port, backing off exponentially
+// between failed attempts. It reports whether the port is now open.
+// Must be called with ioMu held.
+func (s *serialSensor) reconnect() bool {
+	if time.Now().Before(s.nextOpen) {
+		return false
+	}
+	serialPort, err := s.open(s.options)
+	if err != nil {
+		switch {
+		case s.openBackoff == 0:
+			s.openBackoff = time.Second
+		case s.openBackoff < maxOpenBackoff:
+			s.openBackoff *= 2
+		}
+		s.nextOpen = time.Now().Add(s.openBackoff)
+		slog.Error("serial.Open failed, will retry", "portname", s.options.PortName, "retry_in", s.openBackoff, "error", err)
+		return false
+	}
+	slog.Info("reopened serial port", "portname", s.options.PortName, "closed_after", s.disconnectErr)
+	s.port = s.countBytes(serialPort)
+	s.openBackoff = 0
+	s.mu.Lock()
+	s.stats.connected = true
+	s.stats.reconnects++
+	s.mu.Unlock()
+	return true
+}
+
+// openRetrying opens a serial port, retrying up to retries times after failures,
+// waiting interval before the first retry and twice as long before each one after
+// that, up to maxOpenBackoff.
+func openRetrying(open func(serial.OpenOptions) (io.ReadWriteCloser, error), options serial.OpenOptions, retries int, interval time.Duration) (io.ReadWriteCloser, error) {
+	for attempt := 1; ; attempt++ {
+		serialPort, err := open(options)
+		if err == nil || attempt > retries {
+			return serialPort, err
+		}
+		slog.Warn("serial.Open failed, will retry", "portname", options.PortName, "attempt", attempt, "retry_in", interval, "error", err)
+		time.Sleep(interval)
+		interval = min(2*interval, maxOpenBackoff)
+	}
+}
+
+// sendError is returned by serialSensor when it couldn't send the request, which
+// send has already logged and counted.
+type sendError struct {
+	err error
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
+// isSendError reports whether err came from sending a request rather than
+// reading its response.
+func isSendError(err error) bool {
+	var e *sendError
+	return errors.As(err, &e)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// sensors are the collectors for each serial port that opened at startup.
+type sensors []*mhz19Collector
+
+// lookup returns the sensor named by the request's port parameter, which may be
+// omitted if there's only one sensor. If there's no such sensor, it responds
+// with an error and returns nil.
+func (s sensors) lookup(w http.ResponseWriter, r *http.Request) *mhz19Collector {
+	name := r.URL.Query().Get("port")
+	if name == "" {
+		if len(s) == 1 {
+			return s[0]
+		}
+		http.Error(w, "port parameter is required when reading several sensors", http.StatusBadRequest)
+		return nil
+	}
+	for _, c := range s {
+		if c.portname == name {
+			return c
+		}
+	}
+	http.Error(w, fmt.Sprintf("no sensor on port %q", name), http.StatusNotFound)
+	return nil
+}
+
+// handle adapts a handler for one sensor to serve the sensor chosen by lookup.
+func (s sensors) handle(h func(*mhz19Collector, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c := s.lookup(w, r); c != nil {
+			h(c, w, r)
+		}
+	}
+}
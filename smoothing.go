@@ -0,0 +1,34 @@
+package main
+
+// window holds the most recent readings, up to a fixed number, for smoothing.
+type window struct {
+	values []uint16
+	next   int // index to overwrite once values is full
+	size   int
+}
+
+func newWindow(size int) *window {
+	return &window{values: make([]uint16, 0, size), size: size}
+}
+
+// add adds v to the window, dropping the oldest value if it's full.
+func (w *window) add(v uint16) {
+	if len(w.values) < w.size {
+		w.values = append(w.values, v)
+		return
+	}
+	w.values[w.next] = v
+	w.next = (w.next + 1) % w.size
+}
+
+// mean returns the mean of the values in the window, or 0 if it's empty.
+func (w *window) mean() float64 {
+	if len(w.values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range w.values {
+		sum += float64(v)
+	}
+	return sum / float64(len(w.values))
+}
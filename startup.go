@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// startupStep is a command sent to each sensor at startup.
+type startupStep struct {
+	name string // for logs, e.g. "setting detection range"
+	// fatal steps exit the exporter if they fail, since the sensor would be
+	// misconfigured. Others are logged and skipped.
+	fatal bool
+	run   func(c *mhz19Collector) error
+}
+
+// startupSteps returns the steps to run on each sensor at startup, in order,
+// as configured by the flags.
+func startupSteps() []startupStep {
+	var steps []startupStep
+	if *abc != "" {
+		steps = append(steps, startupStep{"setting Automatic Baseline Correction", true, func(c *mhz19Collector) error {
+			if err := c.sensor.Command(newABCCommand(*abc == "on")); err != nil {
+				return err
+			}
+			slog.Info("set Automatic Baseline Correction", "portname", c.portname, "abc", *abc)
+			return nil
+		}})
+	}
+	if *abcPeriod != 0 {
+		steps = append(steps, startupStep{"setting Automatic Baseline Correction period", true, func(c *mhz19Collector) error {
+			if err := c.sensor.Command(newABCPeriodCommand(*abcPeriod)); err != nil {
+				return err
+			}
+			slog.Info("set Automatic Baseline Correction period", "portname", c.portname, "abc_period_hours", *abcPeriod)
+			return nil
+		}})
+	}
+	if *detectionRange != 0 {
+		steps = append(steps, startupStep{"setting detection range", true, func(c *mhz19Collector) error {
+			if err := c.sensor.Command(newDetectionRangeCommand(uint16(*detectionRange))); err != nil {
+				return err
+			}
+			slog.Info("set detection range", "portname", c.portname, "detection_range", *detectionRange)
+			return nil
+		}})
+	}
+	return append(steps,
+		startupStep{"reading detection range", false, func(c *mhz19Collector) error {
+			c.detectionRange = uint16(*detectionRange) // unless the sensor says otherwise
+			ppm, err := c.sensor.DetectionRange()
+			if err != nil {
+				return err
+			}
+			slog.Info("read detection range", "portname", c.portname, "detection_range", ppm)
+			if *detectionRange != 0 && uint(ppm) != *detectionRange {
+				slog.Warn("sensor didn't take the detection range", "portname", c.portname, "detection_range", *detectionRange, "reported", ppm)
+			}
+			if ppm != 0 {
+				c.detectionRange = ppm
+			}
+			return nil
+		}},
+		startupStep{"reading firmware version", false, func(c *mhz19Collector) error {
+			version, err := c.sensor.FirmwareVersion()
+			if err != nil {
+				return err
+			}
+			slog.Info("read firmware version", "portname", c.portname, "version", version)
+			c.firmware = version
+			return nil
+		}},
+	)
+}
+
+// startup runs steps on the sensor in order, retrying each up to readRetries
+// times after errors that might be transient. It exits if a fatal step fails,
+// and otherwise records whether every step that the sensor supports succeeded
+// for the startup_ok metric.
+func (c *mhz19Collector) startup(steps []startupStep) {
+	ok := true
+	for _, step := range steps {
+		slog.Debug("startup step", "portname", c.portname, "step", step.name)
+		err := step.run(c)
+		for attempt := 0; err != nil && !unsupported(err) && attempt < c.readRetries; attempt++ {
+			slog.Warn("startup step failed, retrying", "portname", c.portname, "step", step.name, "error", err)
+			time.Sleep(retryDelay << attempt)
+			err = step.run(c)
+		}
+		switch {
+		case err == nil:
+		case step.fatal:
+			fatal(step.name+" failed", "portname", c.portname, "error", err)
+		case unsupported(err):
+			slog.Warn(step.name+" failed; the sensor may not support it", "portname", c.portname, "error", err)
+		default:
+			slog.Error(step.name+" failed, skipping it", "portname", c.portname, "error", err)
+			ok = false
+		}
+	}
+	c.mu.Lock()
+	c.startupOK = ok
+	c.mu.Unlock()
+}
+
+// unsupported reports whether err means the sensor didn't answer at all, as
+// sensors do to commands their firmware doesn't support, so retrying won't help.
+func unsupported(err error) bool {
+	return errors.Is(err, io.EOF)
+}
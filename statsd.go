@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sendStatsD sends each sensor's latest reading as StatsD gauges, named with
+// prefix, to the StatsD server at addr every interval, until ctx is done. Plain
+// StatsD has no tags, so the serial port's base name is part of the gauge names;
+// DogStatsD tags them with the port instead.
+func sendStatsD(ctx context.Context, addr, prefix string, dogstatsd bool, interval time.Duration, s sensors) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		slog.Error("connecting to StatsD server failed", "addr", addr, "error", err)
+		return
+	}
+	defer conn.Close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, c := range s {
+			r, ok := c.latest()
+			if !ok {
+				continue
+			}
+			packet := statsDPacket(prefix, c.portname, dogstatsd, r)
+			if _, err := conn.Write([]byte(packet)); err != nil {
+				slog.Error("sending to StatsD server failed", "addr", addr, "error", err)
+			}
+		}
+	}
+}
+
+// statsDPacket formats a reading as StatsD gauges, one per line.
+func statsDPacket(prefix, portname string, dogstatsd bool, r reading) string {
+	name := prefix
+	suffix := ""
+	if dogstatsd {
+		suffix = "|#port:" + portname
+	} else {
+		name += "." + strings.ReplaceAll(filepath.Base(portname), ".", "_")
+	}
+	return fmt.Sprintf("%v.co2_ppm:%v|g%v\n%v.temperature_celsius:%v|g%v", name, r.CO2, suffix, name, r.Temperature, suffix)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+func TestStatsDPacket(t *testing.T) {
+	r := reading{CO2: 450, Temperature: 25}
+	for _, tc := range []struct {
+		name      string
+		portname  string
+		dogstatsd bool
+		r         reading
+		want      string
+	}{
+		{
+			name:     "statsd",
+			portname: "/dev/ttyUSB0",
+			r:        r,
+			want:     "mhz19.ttyUSB0.co2_ppm:450|g\nmhz19.ttyUSB0.temperature_celsius:25|g",
+		},
+		{
+			name:     "statsd with a dot in the port",
+			portname: "/dev/serial.0",
+			r:        r,
+			want:     "mhz19.serial_0.co2_ppm:450|g\nmhz19.serial_0.temperature_celsius:25|g",
+		},
+		{
+			name:     "statsd with corrections",
+			portname: "/dev/ttyUSB0",
+			r:        reading{CO2: 463.5, Temperature: 22.5},
+			want:     "mhz19.ttyUSB0.co2_ppm:463.5|g\nmhz19.ttyUSB0.temperature_celsius:22.5|g",
+		},
+		{
+			name:      "dogstatsd",
+			portname:  "/dev/ttyUSB0",
+			dogstatsd: true,
+			r:         r,
+			want:      "mhz19.co2_ppm:450|g|#port:/dev/ttyUSB0\nmhz19.temperature_celsius:25|g|#port:/dev/ttyUSB0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := statsDPacket("mhz19", tc.portname, tc.dogstatsd, tc.r); got != tc.want {
+				t.Errorf("statsDPacket = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSendStatsD(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	mock, _ := openMock(false)(serial.OpenOptions{})
+	c := newTestCollector(t, mock)
+	c.read()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sendStatsD(ctx, server.LocalAddr().String(), "mhz19", false, 10*time.Millisecond, sensors{c})
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no packet received: %v", err)
+	}
+	want := "mhz19.TestSendStatsD.co2_ppm:450|g\nmhz19.TestSendStatsD.temperature_celsius:25|g"
+	if got := string(buf[:n]); got != want {
+		t.Errorf("packet = %q, want %q", got, want)
+	}
+}
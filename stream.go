@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamWriteTimeout is how long a client streaming readings has to accept each
+// one. It replaces --http-write-timeout, which would cut streams off.
+const streamWriteTimeout = 10 * time.Second
+
+// streamPingInterval is how often /stream and /events ping their clients, so
+// that those that went away without closing the connection are noticed.
+const streamPingInterval = 30 * time.Second
+
+var upgrader = websocket.Upgrader{CheckOrigin: checkStreamOrigin}
+
+// checkStreamOrigin allows WebSocket connections from pages on the exporter itself,
+// and on --cors-origin if that's set.
+func checkStreamOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || *corsOrigin == "*" || origin == *corsOrigin {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// serveStream handles GET /stream, a WebSocket that sends each sensor's readings
+// as JSON messages as they're made. Clients that fall behind are disconnected.
+func (b *broadcaster) serveStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has responded with the error
+	}
+	defer conn.Close()
+	readings, unsubscribe := b.subscribe()
+	defer unsubscribe()
+	slog.Debug("streaming readings", "remote_addr", r.RemoteAddr)
+
+	// Clients don't send anything, but reading handles their pongs and close messages.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadDeadline(time.Time{})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-b.done:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"), time.Now().Add(streamWriteTimeout))
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case reading, ok := <-readings:
+			if !ok {
+				slog.Warn("dropped client streaming readings for falling behind", "remote_addr", r.RemoteAddr)
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(streamWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(reading); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// serveEvents handles GET /events, a stream of server-sent events, each a
+// sensor's reading as JSON as it's made, for EventSource in browsers. Clients
+// that fall behind are disconnected.
+func (b *broadcaster) serveEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	readings, unsubscribe := b.subscribe()
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	slog.Debug("streaming readings as events", "remote_addr", r.RemoteAddr)
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n") // a comment, which EventSource ignores
+		case reading, ok := <-readings:
+			if !ok {
+				slog.Warn("dropped client streaming readings for falling behind", "remote_addr", r.RemoteAddr)
+				return
+			}
+			data, err := json.Marshal(reading)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: reading\ndata: %s\n\n", data)
+		}
+	}
+}
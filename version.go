@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Build information, set at build time with e.g.
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Any that aren't set default to what the Go toolchain recorded in the binary, if anything.
+var (
+	version   = "(devel)"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if version == "(devel)" && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && commit == "unknown":
+			commit = setting.Value
+		case setting.Key == "vcs.time" && buildDate == "unknown":
+			buildDate = setting.Value
+		}
+	}
+}
+
+// versionString describes the build, for --version.
+func versionString() string {
+	return fmt.Sprintf("mhz19-exporter version %v, commit %v, built %v with %v", version, commit, buildDate, runtime.Version())
+}
+
+// newBuildInfoGauge returns a gauge, always 1, labelled with the build information.
+func newBuildInfoGauge() prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: prefix + "_exporter_build_info",
+		Help: "Always 1, labelled with the version, commit, build date and Go version of the exporter",
+		ConstLabels: prometheus.Labels{
+			"version":    version,
+			"commit":     commit,
+			"build_date": buildDate,
+			"goversion":  runtime.Version(),
+		},
+	})
+	g.Set(1)
+	return g
+}